@@ -1,12 +1,12 @@
+// Package ai re-exports the sidecar payload type from pkg/ai so that existing
+// imports of bridge/ai keep working. New code should import pkg/ai directly.
 package ai
 
-type MessagePayload struct {
-	Platform   string `json:"platform"`
-	RoomID     string `json:"room_id"`
-	Sender     string `json:"sender"`
-	SenderName string `json:"sender_name"`
-	IsGroup    bool   `json:"is_group"`
-	Timestamp  int64  `json:"timestamp"`
-	Text       string `json:"text"`
-	MessageID  string `json:"message_id"`
-}
+import "github.com/mautrix/whatsapp/pkg/ai"
+
+// MessagePayload is the canonical ai.MessagePayload, so both packages always
+// produce the same JSON.
+type MessagePayload = ai.MessagePayload
+
+// Fails to compile if MessagePayload ever stops being the pkg/ai type.
+var _ ai.MessagePayload = MessagePayload{}
@@ -0,0 +1,54 @@
+package ai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrNotAcknowledged is returned by EmitConfirmed when the sidecar answered,
+// but did not acknowledge the message. It is retried like a 5xx status.
+var ErrNotAcknowledged = errors.New("ai: sidecar did not acknowledge message")
+
+// Ack is the body a sidecar returns with 202 Accepted to acknowledge a
+// message sent by EmitConfirmed.
+type Ack struct {
+	MessageID string `json:"message_id"`
+}
+
+// EmitConfirmed calls Emitter.EmitConfirmed on the package-level emitter.
+func EmitConfirmed(ctx context.Context, payload MessagePayload) error {
+	return loadDefault().EmitConfirmed(ctx, payload)
+}
+
+// EmitConfirmed is like EmitContext, but for messages the sidecar must not
+// miss, such as commands. It only succeeds once the sidecar acknowledges the
+// message:
+//
+//   - The sidecar responds with 202 Accepted after it has durably stored or
+//     handled the message, with an Ack body echoing its message_id.
+//   - Any other 2xx response, or an Ack for another message, fails with
+//     ErrNotAcknowledged and is retried up to MaxRetries.
+//   - Retries carry the same Idempotency-Key, so the sidecar should answer a
+//     message it already accepted with the same Ack.
+func (e *Emitter) EmitConfirmed(ctx context.Context, payload MessagePayload) error {
+	return e.emit(ctx, payload, true)
+}
+
+func checkAck(resp *http.Response, messageID string) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return newStatusError(resp)
+	} else if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("%w: sidecar returned %s instead of 202", ErrNotAcknowledged, resp.Status)
+	}
+	var ack Ack
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxErrorBody)).Decode(&ack); err != nil {
+		return fmt.Errorf("%w: invalid ack: %w", ErrNotAcknowledged, err)
+	} else if ack.MessageID != messageID {
+		return fmt.Errorf("%w: ack is for message %q", ErrNotAcknowledged, ack.MessageID)
+	}
+	return nil
+}
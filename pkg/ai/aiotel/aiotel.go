@@ -0,0 +1,75 @@
+// Package aiotel traces emissions from package ai with OpenTelemetry.
+package aiotel
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// Tracer implements ai.Tracer. Assign it to Emitter.Tracer.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var _ ai.Tracer = (*Tracer)(nil)
+
+// New returns a Tracer that starts spans with tracer and sends their context
+// in the W3C traceparent and tracestate headers.
+func New(tracer trace.Tracer) *Tracer {
+	return &Tracer{tracer: tracer, propagator: propagation.TraceContext{}}
+}
+
+// NewFromProvider is New with a tracer named after this package.
+func NewFromProvider(provider trace.TracerProvider) *Tracer {
+	return New(provider.Tracer("github.com/mautrix/whatsapp/pkg/ai"))
+}
+
+func (t *Tracer) StartEmit(ctx context.Context, info ai.SpanInfo) (context.Context, ai.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("nexa.endpoint", info.Endpoint),
+		attribute.String("nexa.platform", info.Platform),
+	}
+	if info.RoomID != "" {
+		attrs = append(attrs, attribute.String("nexa.room_id", info.RoomID))
+	}
+	if info.MessageID != "" {
+		attrs = append(attrs, attribute.String("nexa.message_id", info.MessageID))
+	}
+	ctx, span := t.tracer.Start(ctx, "ai.emit "+info.Endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx, &emitSpan{ctx: ctx, span: span, propagator: t.propagator}
+}
+
+type emitSpan struct {
+	ctx        context.Context
+	span       trace.Span
+	propagator propagation.TextMapPropagator
+}
+
+func (s *emitSpan) Inject(header http.Header) {
+	s.propagator.Inject(s.ctx, propagation.HeaderCarrier(header))
+}
+
+func (s *emitSpan) RequestDone(statusCode int) {
+	if statusCode != 0 {
+		s.span.SetAttributes(attribute.Int("http.response.status_code", statusCode))
+	}
+}
+
+func (s *emitSpan) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
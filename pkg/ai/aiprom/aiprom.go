@@ -0,0 +1,84 @@
+// Package aiprom exposes emitter metrics from package ai as a Prometheus
+// collector.
+package aiprom
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// Collector implements both ai.Metrics and prometheus.Collector. Register it
+// with a registry and assign it to Emitter.Metrics.
+type Collector struct {
+	emits    *prometheus.CounterVec
+	failures *prometheus.CounterVec
+	filtered *prometheus.CounterVec
+	latency  *prometheus.HistogramVec
+}
+
+var _ ai.Metrics = (*Collector)(nil)
+var _ ai.FilterMetrics = (*Collector)(nil)
+var _ prometheus.Collector = (*Collector)(nil)
+
+// New creates a Collector. It must be registered before its metrics are exported.
+func New() *Collector {
+	return &Collector{
+		emits: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nexa",
+			Subsystem: "ai",
+			Name:      "emits_total",
+			Help:      "Number of payloads emitted to the sidecar.",
+		}, []string{"platform"}),
+		failures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nexa",
+			Subsystem: "ai",
+			Name:      "emit_failures_total",
+			Help:      "Number of emissions that failed, by reason.",
+		}, []string{"platform", "reason"}),
+		filtered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "nexa",
+			Subsystem: "ai",
+			Name:      "emits_filtered_total",
+			Help:      "Number of payloads dropped because of their sender.",
+		}, []string{"platform"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "nexa",
+			Subsystem: "ai",
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests to the sidecar.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"platform"}),
+	}
+}
+
+func (c *Collector) EmitDone(platform string, err error) {
+	c.emits.WithLabelValues(platform).Inc()
+	if err != nil {
+		c.failures.WithLabelValues(platform, ai.FailureReason(err)).Inc()
+	}
+}
+
+func (c *Collector) EmitFiltered(platform string) {
+	c.filtered.WithLabelValues(platform).Inc()
+}
+
+func (c *Collector) RequestDone(platform string, duration time.Duration) {
+	c.latency.WithLabelValues(platform).Observe(duration.Seconds())
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.emits.Describe(ch)
+	c.failures.Describe(ch)
+	c.filtered.Describe(ch)
+	c.latency.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.emits.Collect(ch)
+	c.failures.Collect(ch)
+	c.filtered.Collect(ch)
+	c.latency.Collect(ch)
+}
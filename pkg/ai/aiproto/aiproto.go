@@ -0,0 +1,205 @@
+// Package aiproto provides a protobuf Codec for package ai.
+//
+// The encoding follows payload.proto. It is written by hand with protowire,
+// so no generated code or protoc is needed to build the bridge.
+package aiproto
+
+import (
+	"fmt"
+	"math"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// ContentType is sent as the Content-Type of protobuf bodies.
+const ContentType = "application/x-protobuf"
+
+// Codec implements ai.Codec. Assign it to Emitter.Codec to send protobuf
+// instead of JSON.
+type Codec struct{}
+
+var _ ai.Codec = Codec{}
+
+func (Codec) ContentType() string {
+	return ContentType
+}
+
+func (Codec) Marshal(v any) ([]byte, error) {
+	switch v := v.(type) {
+	case ai.MessagePayload:
+		return appendMessage(nil, &v), nil
+	case []ai.MessagePayload:
+		var b []byte
+		for i := range v {
+			b = appendBytes(b, 1, appendMessage(nil, &v[i]))
+		}
+		return b, nil
+	case ai.ReactionPayload:
+		var b []byte
+		b = appendInt(b, 1, int64(v.SchemaVersion))
+		b = appendString(b, 2, v.Platform)
+		b = appendString(b, 3, v.RoomID)
+		b = appendString(b, 4, v.Sender)
+		b = appendString(b, 5, v.TargetMessageID)
+		b = appendString(b, 6, v.Emoji)
+		b = appendBool(b, 7, v.Removed)
+		b = appendInt(b, 8, v.Timestamp)
+		b = appendString(b, 9, string(v.EventType))
+		b = appendString(b, 10, v.Time)
+		return b, nil
+	case ai.DeletePayload:
+		var b []byte
+		b = appendInt(b, 1, int64(v.SchemaVersion))
+		b = appendString(b, 2, v.Platform)
+		b = appendString(b, 3, v.RoomID)
+		b = appendString(b, 4, v.MessageID)
+		b = appendString(b, 5, string(v.EventType))
+		return b, nil
+	case ai.ReceiptPayload:
+		var b []byte
+		b = appendInt(b, 1, int64(v.SchemaVersion))
+		b = appendString(b, 2, v.Platform)
+		b = appendString(b, 3, v.RoomID)
+		b = appendString(b, 4, v.Sender)
+		b = appendString(b, 5, v.LastReadMessageID)
+		b = appendInt(b, 6, v.Timestamp)
+		b = appendString(b, 7, string(v.EventType))
+		b = appendString(b, 8, v.Time)
+		return b, nil
+	case ai.TypingPayload:
+		var b []byte
+		b = appendInt(b, 1, int64(v.SchemaVersion))
+		b = appendString(b, 2, v.Platform)
+		b = appendString(b, 3, v.RoomID)
+		b = appendString(b, 4, v.Sender)
+		b = appendBool(b, 5, v.Typing)
+		b = appendString(b, 6, string(v.EventType))
+		return b, nil
+	case ai.PresencePayload:
+		var b []byte
+		b = appendInt(b, 1, int64(v.SchemaVersion))
+		b = appendString(b, 2, string(v.EventType))
+		b = appendString(b, 3, v.Platform)
+		b = appendString(b, 4, v.UserID)
+		b = appendString(b, 5, v.Status)
+		b = appendInt(b, 6, v.LastActive)
+		return b, nil
+	default:
+		return nil, fmt.Errorf("aiproto: unsupported payload type %T", v)
+	}
+}
+
+func appendMessage(b []byte, p *ai.MessagePayload) []byte {
+	b = appendInt(b, 1, int64(p.SchemaVersion))
+	b = appendString(b, 2, p.Platform)
+	b = appendString(b, 3, p.RoomID)
+	b = appendString(b, 4, p.Sender)
+	b = appendString(b, 5, p.SenderName)
+	b = appendBool(b, 6, p.IsGroup)
+	b = appendInt(b, 7, p.Timestamp)
+	b = appendString(b, 8, p.Text)
+	b = appendString(b, 9, p.MessageID)
+	for i := range p.Attachments {
+		b = appendBytes(b, 10, appendAttachment(nil, &p.Attachments[i]))
+	}
+	b = appendString(b, 11, p.ReplyToMessageID)
+	b = appendString(b, 12, p.ReplyToSender)
+	b = appendString(b, 13, p.ThreadID)
+	b = appendInt(b, 14, p.EditedAt)
+	b = appendString(b, 15, p.SenderAvatarURL)
+	b = appendString(b, 16, p.SenderColor)
+	b = appendString(b, 17, p.RoomName)
+	b = appendInt(b, 18, int64(p.MemberCount))
+	for _, mention := range p.Mentions {
+		b = appendBytes(b, 19, []byte(mention))
+	}
+	b = appendBool(b, 20, p.MentionsMe)
+	b = appendString(b, 21, string(p.EventType))
+	b = appendString(b, 22, p.Time)
+	b = appendString(b, 23, p.QuotedText)
+	b = appendString(b, 24, p.QuotedSenderName)
+	b = appendString(b, 25, p.FormattedText)
+	b = appendString(b, 26, string(p.Format))
+	b = appendString(b, 27, p.Language)
+	b = appendString(b, 28, p.CorrelationID)
+	if p.Poll != nil {
+		b = appendBytes(b, 29, appendPoll(nil, p.Poll))
+	}
+	if p.Location != nil {
+		b = appendBytes(b, 30, appendLocation(nil, p.Location))
+	}
+	return b
+}
+
+func appendPoll(b []byte, p *ai.PollData) []byte {
+	b = appendString(b, 1, p.Question)
+	for _, option := range p.Options {
+		b = appendBytes(b, 2, []byte(option))
+	}
+	if len(p.Votes) > 0 {
+		var packed []byte
+		for _, votes := range p.Votes {
+			packed = protowire.AppendVarint(packed, uint64(votes))
+		}
+		b = appendBytes(b, 3, packed)
+	}
+	return b
+}
+
+func appendLocation(b []byte, l *ai.LocationData) []byte {
+	b = appendDouble(b, 1, l.Latitude)
+	b = appendDouble(b, 2, l.Longitude)
+	b = appendString(b, 3, l.Label)
+	return b
+}
+
+func appendAttachment(b []byte, a *ai.Attachment) []byte {
+	b = appendString(b, 1, string(a.Type))
+	b = appendString(b, 2, a.URL)
+	b = appendString(b, 3, a.MXC)
+	b = appendString(b, 4, a.MimeType)
+	b = appendInt(b, 5, a.Size)
+	b = appendString(b, 6, a.Caption)
+	return b
+}
+
+// The helpers below skip zero values, as proto3 does for scalar fields.
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytes(b []byte, num protowire.Number, v []byte) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendInt(b []byte, num protowire.Number, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
+
+func appendDouble(b []byte, num protowire.Number, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.Fixed64Type)
+	return protowire.AppendFixed64(b, math.Float64bits(v))
+}
+
+func appendBool(b []byte, num protowire.Number, v bool) []byte {
+	if !v {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, 1)
+}
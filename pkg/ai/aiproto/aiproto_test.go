@@ -0,0 +1,226 @@
+package aiproto_test
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aiproto"
+)
+
+// protoField is a field declared in payload.proto.
+type protoField struct {
+	name     string
+	typ      string
+	repeated bool
+}
+
+var (
+	messageDecl = regexp.MustCompile(`^message (\w+) \{$`)
+	fieldDecl   = regexp.MustCompile(`^(repeated )?(\w+) (\w+) = (\d+);$`)
+)
+
+// readSchema parses the messages of payload.proto, so that the test checks
+// the encoder against the published file rather than against itself.
+func readSchema(t *testing.T) map[string]map[protowire.Number]protoField {
+	t.Helper()
+	data, err := os.ReadFile("payload.proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := make(map[string]map[protowire.Number]protoField)
+	var fields map[protowire.Number]protoField
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if m := messageDecl.FindStringSubmatch(line); m != nil {
+			fields = make(map[protowire.Number]protoField)
+			schema[m[1]] = fields
+		} else if m = fieldDecl.FindStringSubmatch(line); m != nil && fields != nil {
+			num, _ := strconv.Atoi(m[4])
+			fields[protowire.Number(num)] = protoField{name: m[3], typ: m[2], repeated: m[1] != ""}
+		}
+	}
+	return schema
+}
+
+// decode turns an encoded message into the value encoding/json would decode
+// its JSON form into, keyed by the proto field names.
+func decode(t *testing.T, schema map[string]map[protowire.Number]protoField, message string, b []byte) map[string]any {
+	t.Helper()
+	fields, ok := schema[message]
+	if !ok {
+		t.Fatalf("payload.proto has no message %s", message)
+	}
+	out := make(map[string]any)
+	for len(b) > 0 {
+		num, wireType, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("%s: %v", message, protowire.ParseError(n))
+		}
+		b = b[n:]
+		field, ok := fields[num]
+		if !ok {
+			t.Fatalf("%s: field %d is not in payload.proto", message, num)
+		}
+		var values []any
+		switch {
+		case wireType == protowire.BytesType && field.typ == "string":
+			v, n := protowire.ConsumeString(b)
+			b, values = b[max(n, 0):], []any{v}
+		case wireType == protowire.BytesType && field.typ == "int64" && field.repeated:
+			packed, n := protowire.ConsumeBytes(b)
+			b = b[max(n, 0):]
+			for len(packed) > 0 {
+				v, n := protowire.ConsumeVarint(packed)
+				if n < 0 {
+					t.Fatalf("%s.%s: %v", message, field.name, protowire.ParseError(n))
+				}
+				packed, values = packed[n:], append(values, float64(int64(v)))
+			}
+		case wireType == protowire.BytesType && schema[field.typ] != nil:
+			v, n := protowire.ConsumeBytes(b)
+			b, values = b[max(n, 0):], []any{decode(t, schema, field.typ, v)}
+		case wireType == protowire.VarintType && field.typ == "int64":
+			v, n := protowire.ConsumeVarint(b)
+			b, values = b[max(n, 0):], []any{float64(int64(v))}
+		case wireType == protowire.VarintType && field.typ == "bool":
+			v, n := protowire.ConsumeVarint(b)
+			b, values = b[max(n, 0):], []any{protowire.DecodeBool(v)}
+		case wireType == protowire.Fixed64Type && field.typ == "double":
+			v, n := protowire.ConsumeFixed64(b)
+			b, values = b[max(n, 0):], []any{math.Float64frombits(v)}
+		default:
+			t.Fatalf("%s.%s: wire type %d doesn't match %s", message, field.name, wireType, field.typ)
+		}
+		if field.repeated {
+			list, _ := out[field.name].([]any)
+			out[field.name] = append(list, values...)
+		} else if _, dup := out[field.name]; dup {
+			t.Fatalf("%s.%s is encoded twice", message, field.name)
+		} else {
+			out[field.name] = values[0]
+		}
+	}
+	return out
+}
+
+// checkComplete fails if any field of v is left zero, so that fields added
+// to the structs later can't miss the round trip.
+func checkComplete(t *testing.T, name string, v reflect.Value) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.Pointer:
+		checkComplete(t, name, v.Elem())
+	case reflect.Slice:
+		if v.Len() == 0 {
+			t.Fatalf("%s is empty", name)
+		}
+		checkComplete(t, name+"[0]", v.Index(0))
+	case reflect.Struct:
+		for i := range v.NumField() {
+			checkComplete(t, name+"."+v.Type().Field(i).Name, v.Field(i))
+		}
+	default:
+		if v.IsZero() {
+			t.Fatalf("%s is zero", name)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	schema := readSchema(t)
+	message := ai.MessagePayload{
+		SchemaVersion:    ai.SchemaVersion,
+		EventType:        ai.EventMessage,
+		Platform:         ai.PlatformWhatsApp,
+		RoomID:           "!room",
+		Sender:           "@alice",
+		SenderName:       "Alice",
+		IsGroup:          true,
+		Timestamp:        1700000000000,
+		Time:             "2023-11-14T22:13:20Z",
+		Text:             "hi",
+		MessageID:        "msg1",
+		FormattedText:    "<b>hi</b>",
+		Format:           ai.FormatHTML,
+		Language:         "en",
+		Attachments:      []ai.Attachment{{Type: ai.AttachmentImage, URL: "https://example.com/a.png", MXC: "mxc://example.com/a", MimeType: "image/png", Size: 1234, Caption: "a"}},
+		Poll:             &ai.PollData{Question: "lunch?", Options: []string{"yes", "no"}, Votes: []int{3, 1}},
+		Location:         &ai.LocationData{Latitude: 52.52, Longitude: -13.4, Label: "Berlin"},
+		ReplyToMessageID: "msg0",
+		ReplyToSender:    "@bob",
+		QuotedText:       "hello",
+		QuotedSenderName: "Bob",
+		ThreadID:         "thread1",
+		EditedAt:         1700000001000,
+		SenderAvatarURL:  "mxc://example.com/avatar",
+		SenderColor:      "#ff0000",
+		RoomName:         "Lunch",
+		MemberCount:      3,
+		Mentions:         []string{"@bob", "@carol"},
+		MentionsMe:       true,
+		CorrelationID:    "corr1",
+	}
+	cases := []struct {
+		proto string
+		value any
+	}{
+		{"MessagePayload", message},
+		{"ReactionPayload", ai.ReactionPayload{
+			SchemaVersion: ai.SchemaVersion, EventType: ai.EventReaction, Platform: ai.PlatformWhatsApp,
+			RoomID: "!room", Sender: "@alice", TargetMessageID: "msg1", Emoji: "👍", Removed: true,
+			Timestamp: 1700000000000, Time: "2023-11-14T22:13:20Z",
+		}},
+		{"DeletePayload", ai.DeletePayload{
+			SchemaVersion: ai.SchemaVersion, EventType: ai.EventDelete, Platform: ai.PlatformWhatsApp,
+			RoomID: "!room", MessageID: "msg1",
+		}},
+		{"ReceiptPayload", ai.ReceiptPayload{
+			SchemaVersion: ai.SchemaVersion, EventType: ai.EventReceipt, Platform: ai.PlatformWhatsApp,
+			RoomID: "!room", Sender: "@alice", LastReadMessageID: "msg1",
+			Timestamp: 1700000000000, Time: "2023-11-14T22:13:20Z",
+		}},
+		{"TypingPayload", ai.TypingPayload{
+			SchemaVersion: ai.SchemaVersion, EventType: ai.EventTyping, Platform: ai.PlatformWhatsApp,
+			RoomID: "!room", Sender: "@alice", Typing: true,
+		}},
+		{"PresencePayload", ai.PresencePayload{
+			SchemaVersion: ai.SchemaVersion, EventType: ai.EventPresence, Platform: ai.PlatformWhatsApp,
+			UserID: "@alice", Status: "online", LastActive: 1700000000000,
+		}},
+		{"MessageBatch", []ai.MessagePayload{message, message}},
+	}
+	for _, c := range cases {
+		checkComplete(t, c.proto, reflect.ValueOf(c.value))
+		data, err := aiproto.Codec{}.Marshal(c.value)
+		if err != nil {
+			t.Fatalf("%s: %v", c.proto, err)
+		}
+		got := decode(t, schema, c.proto, data)
+
+		// The proto field names match the JSON ones, so the JSON encoding
+		// tells what every field should decode to.
+		jsonData, err := json.Marshal(c.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var want any
+		if err = json.Unmarshal(jsonData, &want); err != nil {
+			t.Fatal(err)
+		}
+		if c.proto == "MessageBatch" {
+			want = map[string]any{"messages": want}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s decoded to\n%v\nwant\n%v", c.proto, got, want)
+		}
+	}
+}
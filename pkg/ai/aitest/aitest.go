@@ -0,0 +1,172 @@
+// Package aitest provides a mock sidecar for testing code that emits
+// payloads with package ai.
+package aitest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// Fault describes how the Recorder misbehaves for a request. Requests that
+// hit a fault are not recorded.
+type Fault struct {
+	// StatusCode is sent instead of 200 OK if it is not zero.
+	StatusCode int
+	// Delay is waited before responding.
+	Delay time.Duration
+	// Drop closes the connection without sending a response.
+	Drop bool
+}
+
+// Recorder is a running mock sidecar that captures every message it
+// receives on /message, /messages and /edit. Check EventType to tell edits
+// apart. Bodies must be JSON, optionally gzipped.
+type Recorder struct {
+	*httptest.Server
+
+	lock     sync.Mutex
+	received []ai.MessagePayload
+	requests int
+	faults   []Fault
+	closed   chan struct{}
+}
+
+// NewRecorder starts a Recorder. Call Close when done with it.
+func NewRecorder() *Recorder {
+	r := &Recorder{closed: make(chan struct{})}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// MessageURL returns the URL of the recorder's message endpoint.
+func (r *Recorder) MessageURL() string {
+	return r.URL + "/message"
+}
+
+// Emitter returns an Emitter that sends to the recorder.
+func (r *Recorder) Emitter() *ai.Emitter {
+	return &ai.Emitter{URL: r.MessageURL(), Client: r.Client()}
+}
+
+// Received returns a copy of the messages received so far, in order.
+func (r *Recorder) Received() []ai.MessagePayload {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]ai.MessagePayload(nil), r.received...)
+}
+
+// Requests returns the number of requests made so far, including failed ones.
+func (r *Recorder) Requests() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.requests
+}
+
+// FailNext makes the next n requests fail with fault.
+func (r *Recorder) FailNext(n int, fault Fault) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for range n {
+		r.faults = append(r.faults, fault)
+	}
+}
+
+// Reset forgets the recorded messages, request count and pending faults.
+func (r *Recorder) Reset() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.received = nil
+	r.requests = 0
+	r.faults = nil
+}
+
+// Close stops the server, cutting short any delayed responses.
+func (r *Recorder) Close() {
+	select {
+	case <-r.closed:
+	default:
+		close(r.closed)
+	}
+	r.Server.Close()
+}
+
+func (r *Recorder) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	r.requests++
+	var fault *Fault
+	if len(r.faults) > 0 {
+		fault = &r.faults[0]
+		r.faults = r.faults[1:]
+	}
+	r.lock.Unlock()
+
+	if fault != nil {
+		r.fail(w, req, fault)
+		return
+	}
+	var body io.Reader = req.Body
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	var payloads []ai.MessagePayload
+	var err error
+	switch {
+	case strings.HasSuffix(req.URL.Path, "/messages"):
+		err = json.NewDecoder(body).Decode(&payloads)
+	case strings.HasSuffix(req.URL.Path, "/message"), strings.HasSuffix(req.URL.Path, "/edit"):
+		payloads = make([]ai.MessagePayload, 1)
+		err = json.NewDecoder(body).Decode(&payloads[0])
+	default:
+		_, err = io.Copy(io.Discard, body)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	r.lock.Lock()
+	r.received = append(r.received, payloads...)
+	r.lock.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *Recorder) fail(w http.ResponseWriter, req *http.Request, fault *Fault) {
+	if fault.Delay > 0 {
+		timer := time.NewTimer(fault.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			return
+		case <-r.closed:
+			return
+		}
+	}
+	if fault.Drop {
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+	status := fault.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+}
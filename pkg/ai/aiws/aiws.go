@@ -0,0 +1,224 @@
+// Package aiws emits payloads over a persistent WebSocket connection instead
+// of one HTTP request per message, and receives frames pushed back by the
+// sidecar.
+package aiws
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// DefaultInboundBuffer is used when Options.InboundBuffer is not positive.
+const DefaultInboundBuffer = 64
+
+// maxReconnectBackoff caps the delay between reconnection attempts.
+const maxReconnectBackoff = 30 * time.Second
+
+// maxInboundBytes is the largest frame accepted from the sidecar.
+const maxInboundBytes = 1 << 20
+
+// Options configures Dial.
+type Options struct {
+	// Header is sent with every handshake, e.g. for an Authorization header.
+	Header http.Header
+	// HTTPClient is used for the handshake. Defaults to http.DefaultClient.
+	HTTPClient *http.Client
+	// InboundBuffer is the capacity of the Inbound channel. Frames arriving
+	// while it is full are dropped.
+	InboundBuffer int
+	// Logger receives connection errors. Defaults to slog.Default().
+	Logger *slog.Logger
+	// Config supplies the settings payloads are processed with before they
+	// are sent, exactly as over HTTP: platform aliases, sender filters, the
+	// Redactor, interceptors, truncation, MaxPayloadBytes and the Codec or
+	// Serializer. Its URL and HTTP settings are ignored. Defaults to a zero
+	// ai.Emitter.
+	Config *ai.Emitter
+}
+
+// Emitter sends every payload as a frame over one WebSocket
+// connection, reconnecting automatically when it drops. It implements
+// ai.MessageEmitter and is safe for concurrent use.
+type Emitter struct {
+	url     string
+	opts    Options
+	log     *slog.Logger
+	inbound chan json.RawMessage
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	lock sync.Mutex
+	conn *websocket.Conn
+}
+
+var _ ai.MessageEmitter = (*Emitter)(nil)
+
+// NewWebSocketEmitter calls Dial with default options.
+func NewWebSocketEmitter(rawURL string) (*Emitter, error) {
+	return Dial(context.Background(), rawURL, Options{})
+}
+
+// Dial connects to the ws:// or wss:// URL of the sidecar. It fails if the
+// first connection can't be established; later disconnections are retried
+// in the background until Close is called.
+func Dial(ctx context.Context, rawURL string, opts Options) (*Emitter, error) {
+	if opts.InboundBuffer <= 0 {
+		opts.InboundBuffer = DefaultInboundBuffer
+	}
+	e := &Emitter{
+		url:     rawURL,
+		opts:    opts,
+		log:     opts.Logger,
+		inbound: make(chan json.RawMessage, opts.InboundBuffer),
+		done:    make(chan struct{}),
+	}
+	if e.log == nil {
+		e.log = slog.Default()
+	}
+	if e.opts.Config == nil {
+		e.opts.Config = &ai.Emitter{}
+	}
+	conn, err := e.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	e.conn = conn
+	go e.run(conn)
+	return e, nil
+}
+
+func (e *Emitter) dial(ctx context.Context) (*websocket.Conn, error) {
+	conn, _, err := websocket.Dial(ctx, e.url, &websocket.DialOptions{
+		HTTPClient: e.opts.HTTPClient,
+		HTTPHeader: e.opts.Header,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ai.ErrSidecarUnavailable, err)
+	}
+	conn.SetReadLimit(maxInboundBytes)
+	return conn, nil
+}
+
+// Inbound returns the frames received from the sidecar, such as replies or
+// commands. It is closed after Close.
+func (e *Emitter) Inbound() <-chan json.RawMessage {
+	return e.inbound
+}
+
+func (e *Emitter) Emit(payload ai.MessagePayload) error {
+	return e.EmitContext(context.Background(), payload)
+}
+
+// EmitContext processes payload according to Options.Config and sends it as
+// a single frame: a text frame for JSON, a binary one for other codecs. It
+// fails with ai.ErrSidecarUnavailable while the connection is being
+// re-established.
+func (e *Emitter) EmitContext(ctx context.Context, payload ai.MessagePayload) error {
+	data, contentType, err := e.opts.Config.EncodeMessage(payload)
+	if err != nil {
+		return err
+	} else if data == nil {
+		return nil
+	}
+	frameType := websocket.MessageBinary
+	if strings.Contains(contentType, "json") {
+		frameType = websocket.MessageText
+	}
+	e.lock.Lock()
+	conn := e.conn
+	e.lock.Unlock()
+	if conn == nil {
+		return fmt.Errorf("%w: websocket is reconnecting", ai.ErrSidecarUnavailable)
+	}
+	if err = conn.Write(ctx, frameType, data); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("%w: %w", ai.ErrSidecarUnavailable, err)
+	}
+	return nil
+}
+
+// Close closes the connection and stops reconnecting.
+func (e *Emitter) Close() error {
+	e.cancel()
+	e.lock.Lock()
+	conn := e.conn
+	e.lock.Unlock()
+	var err error
+	if conn != nil {
+		err = conn.Close(websocket.StatusNormalClosure, "")
+	}
+	<-e.done
+	return err
+}
+
+func (e *Emitter) run(conn *websocket.Conn) {
+	defer close(e.done)
+	defer close(e.inbound)
+	for conn != nil {
+		err := e.read(conn)
+		e.lock.Lock()
+		e.conn = nil
+		e.lock.Unlock()
+		if e.ctx.Err() != nil {
+			return
+		}
+		e.log.Warn("Sidecar websocket disconnected", slog.Any("error", err))
+		conn = e.reconnect()
+	}
+}
+
+func (e *Emitter) read(conn *websocket.Conn) error {
+	for {
+		typ, data, err := conn.Read(e.ctx)
+		if err != nil {
+			return err
+		} else if typ != websocket.MessageText {
+			continue
+		}
+		select {
+		case e.inbound <- data:
+		default:
+			e.log.Warn("Dropping sidecar websocket frame, inbound channel is full")
+		}
+	}
+}
+
+// reconnect dials until it succeeds or the emitter is closed, in which case
+// it returns nil.
+func (e *Emitter) reconnect() *websocket.Conn {
+	backoff := ai.DefaultBaseBackoff
+	for {
+		select {
+		case <-time.After(backoff):
+		case <-e.ctx.Done():
+			return nil
+		}
+		conn, err := e.dial(e.ctx)
+		if err == nil {
+			e.lock.Lock()
+			e.conn = conn
+			e.lock.Unlock()
+			e.log.Info("Reconnected to sidecar websocket")
+			return conn
+		} else if e.ctx.Err() != nil {
+			return nil
+		}
+		e.log.Debug("Failed to reconnect to sidecar websocket", slog.Any("error", err))
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
@@ -0,0 +1,69 @@
+package aiws_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aiws"
+)
+
+func TestEmitterSharesPipeline(t *testing.T) {
+	frames := make(chan []byte, 4)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.CloseNow()
+		for {
+			_, data, err := conn.Read(r.Context())
+			if err != nil {
+				return
+			}
+			frames <- data
+		}
+	}))
+	defer srv.Close()
+
+	e, err := aiws.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"), aiws.Options{
+		Config: &ai.Emitter{SelfID: "@bridge", Redactor: ai.NewRegexRedactor()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	own := ai.NewMessagePayload("WA", "!room", "@bridge", "own", time.Now())
+	if err = e.Emit(own); err != nil {
+		t.Fatalf("Emit own message: %v", err)
+	}
+	payload := ai.NewMessagePayload("WA", "!room", "@alice", "msg1", time.Now())
+	payload.Text = "mail bob@example.com"
+	if err = e.Emit(payload); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+
+	select {
+	case data := <-frames:
+		var got ai.MessagePayload
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		} else if got.MessageID != "msg1" {
+			t.Fatalf("got message %s first, want the bridge's own message filtered out", got.MessageID)
+		} else if got.Platform != ai.PlatformWhatsApp || got.EventType != ai.EventMessage {
+			t.Errorf("payload wasn't prepared: platform %q, event type %q", got.Platform, got.EventType)
+		} else if strings.Contains(got.Text, "bob@example.com") || got.CorrelationID == "" {
+			t.Errorf("payload wasn't redacted or prepared: %+v", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no frame received")
+	}
+}
@@ -0,0 +1,167 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// BatchEmitter accumulates payloads and posts them together.
+//
+// A batch is sent as a JSON array of MessagePayload objects to the "messages"
+// endpoint next to the emitter's message URL (e.g. /message -> /messages).
+// Payloads of platforms routed to their own sidecar with PlatformRoutes are
+// sent to it as a separate batch. It is flushed when it reaches maxBatch
+// payloads or when flushInterval elapses, whichever comes first.
+type BatchEmitter struct {
+	emitter  *Emitter
+	maxBatch int
+
+	lock  sync.Mutex
+	batch []MessagePayload
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBatchEmitter returns a BatchEmitter that uses the package-level emitter.
+func NewBatchEmitter(maxBatch int, flushInterval time.Duration) *BatchEmitter {
+	return loadDefault().NewBatchEmitter(maxBatch, flushInterval)
+}
+
+// NewBatchEmitter returns a BatchEmitter that sends batches through e.
+// A non-positive flushInterval disables time-based flushing.
+func (e *Emitter) NewBatchEmitter(maxBatch int, flushInterval time.Duration) *BatchEmitter {
+	if maxBatch <= 0 {
+		maxBatch = 1
+	}
+	b := &BatchEmitter{
+		emitter:  e,
+		maxBatch: maxBatch,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if flushInterval > 0 {
+		go b.run(flushInterval)
+	} else {
+		close(b.done)
+	}
+	return b
+}
+
+// Add appends payload to the current batch. If that fills the batch, it is
+// flushed before Add returns. Invalid payloads are rejected immediately.
+func (b *BatchEmitter) Add(payload MessagePayload) error {
+	if ok, err := b.emitter.prepareMessage(&payload); !ok {
+		return err
+	}
+	b.lock.Lock()
+	b.batch = append(b.batch, payload)
+	if len(b.batch) < b.maxBatch {
+		b.lock.Unlock()
+		return nil
+	}
+	batch := b.take()
+	b.lock.Unlock()
+	return b.emitter.emitBatch(context.Background(), batch)
+}
+
+// Flush sends the current batch immediately.
+func (b *BatchEmitter) Flush() error {
+	b.lock.Lock()
+	batch := b.take()
+	b.lock.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return b.emitter.emitBatch(context.Background(), batch)
+}
+
+// Close stops the flush timer and sends whatever is left in the batch.
+func (b *BatchEmitter) Close() error {
+	select {
+	case <-b.stop:
+	default:
+		close(b.stop)
+	}
+	<-b.done
+	return b.Flush()
+}
+
+func (b *BatchEmitter) take() []MessagePayload {
+	batch := b.batch
+	b.batch = nil
+	return batch
+}
+
+func (b *BatchEmitter) run(interval time.Duration) {
+	defer close(b.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				b.emitter.logger().Warn("Failed to flush message batch", slog.Any("error", err))
+			}
+		case <-b.stop:
+			return
+		}
+	}
+}
+
+// emitBatch posts payloads to the "messages" endpoint. Payloads of platforms
+// with their own route in PlatformRoutes are posted to that sidecar as a
+// separate batch; the order of payloads within each batch is kept.
+func (e *Emitter) emitBatch(ctx context.Context, payloads []MessagePayload) error {
+	var groups [][]MessagePayload
+	index := make(map[string]int)
+	for _, payload := range payloads {
+		var key string
+		if _, ok := e.route(payload.Platform); ok {
+			key = payload.Platform
+		}
+		i, ok := index[key]
+		if !ok {
+			i = len(groups)
+			index[key] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], payload)
+	}
+	var errs []error
+	for _, group := range groups {
+		errs = append(errs, e.send(ctx, emission{
+			platform: group[0].Platform,
+			endpoint: "messages",
+			body:     group,
+		}))
+	}
+	return errors.Join(errs...)
+}
+
+// EmitBatch calls Emitter.EmitBatch on the package-level emitter.
+func EmitBatch(ctx context.Context, payloads []MessagePayload) []error {
+	return loadDefault().EmitBatch(ctx, payloads)
+}
+
+// EmitBatch emits payloads one by one through EmitContext, so each of them is
+// rate limited and retried on its own, and returns their errors by index.
+// Unlike a BatchEmitter, it reports which payloads failed; a nil entry means
+// the payload was delivered (or filtered out). If ctx ends mid-batch, the
+// payloads not yet attempted fail with ctx.Err().
+func (e *Emitter) EmitBatch(ctx context.Context, payloads []MessagePayload) []error {
+	errs := make([]error, len(payloads))
+	for i, payload := range payloads {
+		if err := ctx.Err(); err != nil {
+			for j := i; j < len(errs); j++ {
+				errs[j] = err
+			}
+			break
+		}
+		errs[i] = e.EmitContext(ctx, payload)
+	}
+	return errs
+}
@@ -0,0 +1,37 @@
+package ai_test
+
+import (
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestBatchPlatformRoutes(t *testing.T) {
+	main, telegram := aitest.NewRecorder(), aitest.NewRecorder()
+	defer main.Close()
+	defer telegram.Close()
+	e := main.Emitter()
+	e.PlatformRoutes = map[string]string{ai.PlatformTelegram: telegram.MessageURL()}
+
+	b := e.NewBatchEmitter(10, 0)
+	for i, platform := range []string{ai.PlatformWhatsApp, ai.PlatformTelegram, ai.PlatformWhatsApp} {
+		payload := testPayload(string(rune('a' + i)))
+		payload.Platform = platform
+		if err := b.Add(payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := main.Received(); len(got) != 2 || got[0].MessageID != "a" || got[1].MessageID != "c" {
+		t.Errorf("main sidecar received %+v, want the two whatsapp messages in order", got)
+	}
+	if got := telegram.Received(); len(got) != 1 || got[0].Platform != ai.PlatformTelegram {
+		t.Errorf("telegram sidecar received %+v, want the telegram message", got)
+	}
+	if main.Requests() != 1 || telegram.Requests() != 1 {
+		t.Errorf("got %d and %d requests, want one batch per sidecar", main.Requests(), telegram.Requests())
+	}
+}
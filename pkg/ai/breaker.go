@@ -0,0 +1,83 @@
+package ai
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the sidecar while the
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("ai: circuit breaker is open")
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// CircuitBreaker stops emissions for Cooldown after Threshold consecutive
+// failed emissions. After the cooldown a single emission is let through to
+// probe the sidecar: if it succeeds the breaker closes, otherwise it opens
+// again.
+//
+// A failure is an emission that still failed with a connection error or a
+// 5xx response after all retries, so retries of a single emission count once.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	lock      sync.Mutex
+	state     breakerState
+	failures  int
+	openUntil time.Time
+}
+
+// NewCircuitBreaker returns a closed breaker.
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown}
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return ErrCircuitOpen
+		}
+		b.state = breakerHalfOpen
+		return nil
+	case breakerHalfOpen:
+		// A probe is already in flight.
+		return ErrCircuitOpen
+	default:
+		return nil
+	}
+}
+
+func (b *CircuitBreaker) record(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if err == nil || !isRetryable(err) {
+		b.state = breakerClosed
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= max(b.Threshold, 1) {
+		b.state = breakerOpen
+		b.openUntil = time.Now().Add(b.Cooldown)
+	}
+}
+
+// cancel releases a half-open probe that ended without a result.
+func (b *CircuitBreaker) cancel() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
@@ -0,0 +1,89 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+// Capabilities lists the event types a sidecar accepts, as returned by its
+// "capabilities" endpoint, e.g. {"event_types": ["message", "edit"]}.
+type Capabilities struct {
+	EventTypes []EventType `json:"event_types"`
+}
+
+// Supports reports whether the sidecar accepts events of type t. A nil
+// Capabilities supports everything.
+func (c *Capabilities) Supports(t EventType) bool {
+	return c == nil || slices.Contains(c.EventTypes, t)
+}
+
+// endpointEvents maps endpoint names to the event type they receive.
+var endpointEvents = map[string]EventType{
+	"":         EventMessage,
+	"messages": EventMessage,
+	"edit":     EventEdit,
+	"reaction": EventReaction,
+	"delete":   EventDelete,
+	"typing":   EventTyping,
+	"receipt":  EventReceipt,
+	"presence": EventPresence,
+}
+
+// unsupportedLogged holds the event types already logged as unsupported.
+var unsupportedLogged sync.Map
+
+// supports reports whether em may be sent according to e.Capabilities.
+func (e *Emitter) supports(em *emission) bool {
+	eventType := endpointEvents[em.endpoint]
+	if e.Capabilities.Supports(eventType) {
+		return true
+	}
+	if _, logged := unsupportedLogged.LoadOrStore(eventType, true); !logged {
+		e.logger().Debug("Sidecar does not support event type, not sending it",
+			slog.String("event_type", string(eventType)),
+		)
+	}
+	return false
+}
+
+// NegotiateCapabilities fetches the sidecar's capabilities and stores them in
+// the package-level emitter.
+func NegotiateCapabilities(ctx context.Context) error {
+	caps, err := loadDefault().FetchCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	return updateDefault(func(e *Emitter) error {
+		e.Capabilities = caps
+		return nil
+	})
+}
+
+// FetchCapabilities requests the "capabilities" endpoint of the primary
+// sidecar. If the sidecar doesn't implement it and responds with 404, it
+// returns nil, which enables all event types. Assign the result to
+// Emitter.Capabilities before the emitter is used.
+func (e *Emitter) FetchCapabilities(ctx context.Context) (*Capabilities, error) {
+	target, err := e.endpoint("capabilities")
+	if err != nil {
+		return nil, err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, e.timeout(nil))
+	defer cancel()
+	req, err := e.newRequest(reqCtx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	var caps Capabilities
+	var statusErr *StatusError
+	if _, err = e.do(ctx, req, &caps, ""); errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
@@ -0,0 +1,115 @@
+package ai
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// DefaultEditWindow is used by NewEditCoalescer when window is not positive.
+const DefaultEditWindow = 500 * time.Millisecond
+
+// EditCoalescer collapses bursts of edits to the same message. The first edit
+// of a message starts a window; edits arriving during it replace the pending
+// one, and only the latest is sent through EmitEdit when the window ends.
+// New messages and other events are not affected and should be emitted
+// directly.
+type EditCoalescer struct {
+	emitter *Emitter
+	window  time.Duration
+
+	lock    sync.Mutex
+	closed  bool
+	pending map[string]*pendingEdit
+	wg      sync.WaitGroup
+}
+
+type pendingEdit struct {
+	payload MessagePayload
+	timer   *time.Timer
+}
+
+// NewEditCoalescer returns an EditCoalescer that uses the package-level
+// emitter.
+func NewEditCoalescer(window time.Duration) *EditCoalescer {
+	return loadDefault().NewEditCoalescer(window)
+}
+
+// NewEditCoalescer returns an EditCoalescer that sends edits through e.
+func (e *Emitter) NewEditCoalescer(window time.Duration) *EditCoalescer {
+	if window <= 0 {
+		window = DefaultEditWindow
+	}
+	return &EditCoalescer{
+		emitter: e,
+		window:  window,
+		pending: make(map[string]*pendingEdit),
+	}
+}
+
+// EmitEdit queues payload as the latest edit of its message and returns
+// immediately. Invalid payloads are rejected right away; failures to send
+// the edit later are logged by the emitter's logger.
+func (c *EditCoalescer) EmitEdit(payload MessagePayload) error {
+	payload.Platform = c.emitter.normalizePlatform(payload.Platform)
+	if err := payload.Validate(); err != nil {
+		return err
+	} else if payload.EditedAt == 0 {
+		// Stamp the time of the edit, not the time it is sent.
+		payload.EditedAt = time.Now().UnixMilli()
+	}
+	key := payload.Platform + "\x00" + payload.MessageID
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return ErrClosed
+	} else if edit, ok := c.pending[key]; ok {
+		edit.payload = payload
+		return nil
+	}
+	c.wg.Add(1)
+	c.pending[key] = &pendingEdit{
+		payload: payload,
+		timer:   time.AfterFunc(c.window, func() { c.fire(key) }),
+	}
+	return nil
+}
+
+func (c *EditCoalescer) fire(key string) {
+	defer c.wg.Done()
+	c.lock.Lock()
+	edit := c.pending[key]
+	delete(c.pending, key)
+	c.lock.Unlock()
+	if err := c.emitter.EmitEdit(edit.payload); err != nil {
+		c.emitter.logger().Warn("Failed to emit coalesced edit",
+			slog.String("platform", edit.payload.Platform),
+			slog.String("message_id", edit.payload.MessageID),
+			slog.Any("error", err),
+		)
+	}
+}
+
+// Close sends the pending edits without waiting for their windows to end and
+// returns their errors. EmitEdit returns ErrClosed afterwards.
+func (c *EditCoalescer) Close() error {
+	c.lock.Lock()
+	c.closed = true
+	var edits []MessagePayload
+	for key, edit := range c.pending {
+		// Edits whose timer already fired are being sent by fire.
+		if edit.timer.Stop() {
+			edits = append(edits, edit.payload)
+			delete(c.pending, key)
+		}
+	}
+	c.lock.Unlock()
+	var errs []error
+	for _, payload := range edits {
+		errs = append(errs, c.emitter.EmitEdit(payload))
+		c.wg.Done()
+	}
+	c.wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,37 @@
+package ai_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestEditCoalescer(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	const window = 100 * time.Millisecond
+	c := rec.Emitter().NewEditCoalescer(window)
+
+	for _, text := range []string{"edit 1", "edit 2", "edit 3"} {
+		payload := testPayload("msg1")
+		payload.Text = text
+		if err := c.EmitEdit(payload); err != nil {
+			t.Fatalf("EmitEdit: %v", err)
+		}
+	}
+	if n := rec.Requests(); n != 0 {
+		t.Fatalf("%d requests were made before the window ended", n)
+	}
+	time.Sleep(3 * window)
+	received := rec.Received()
+	if len(received) != 1 {
+		t.Fatalf("got %d edits, want 1", len(received))
+	} else if received[0].EventType != ai.EventEdit || received[0].Text != "edit 3" {
+		t.Fatalf("got %s %q, want the final edit", received[0].EventType, received[0].Text)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
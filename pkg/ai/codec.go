@@ -0,0 +1,155 @@
+package ai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Codec encodes payloads for the wire. Marshal is called with a payload
+// struct from this package or a []MessagePayload for batches.
+type Codec interface {
+	// ContentType is sent as the Content-Type of encoded bodies.
+	ContentType() string
+	Marshal(v any) ([]byte, error)
+}
+
+// Serializer encodes a single message into a request body and returns it with
+// its content type. Unlike a Codec, it only sees messages, which makes it easy
+// to wrap them in an envelope of the sidecar's choosing. Batches and other
+// events are still encoded by the Codec.
+type Serializer interface {
+	Marshal(payload MessagePayload) (body []byte, contentType string, err error)
+}
+
+// FieldNaming selects how JSONCodec spells object keys.
+type FieldNaming int
+
+const (
+	// NamingSnake uses the snake_case names from the struct tags, e.g.
+	// "room_id". It is the default.
+	NamingSnake FieldNaming = iota
+	// NamingCamel rewrites every key to camelCase, e.g. "roomId".
+	NamingCamel
+)
+
+// JSONCodec encodes payloads as JSON. It is the default codec.
+type JSONCodec struct {
+	// Naming selects the spelling of object keys. Defaults to NamingSnake.
+	Naming FieldNaming
+}
+
+func (JSONCodec) ContentType() string {
+	return "application/json"
+}
+
+func (c JSONCodec) Marshal(v any) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || c.Naming != NamingCamel {
+		return data, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var buf bytes.Buffer
+	buf.Grow(len(data))
+	if err = renameKeys(dec, &buf, snakeToCamel); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renameKeys copies the next JSON value from dec to buf, passing every object
+// key through rename. Key order is preserved.
+func renameKeys(dec *json.Decoder, buf *bytes.Buffer, rename func(string) string) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	delim, ok := tok.(json.Delim)
+	if !ok {
+		data, err := json.Marshal(tok)
+		buf.Write(data)
+		return err
+	}
+	switch delim {
+	case '{':
+		buf.WriteByte('{')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			tok, err = dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := tok.(string)
+			if !ok {
+				return fmt.Errorf("ai: unexpected JSON object key %v", tok)
+			}
+			data, _ := json.Marshal(rename(key))
+			buf.Write(data)
+			buf.WriteByte(':')
+			if err = renameKeys(dec, buf, rename); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case '[':
+		buf.WriteByte('[')
+		for i := 0; dec.More(); i++ {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err = renameKeys(dec, buf, rename); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	}
+	// Consume the closing delimiter.
+	_, err = dec.Token()
+	return err
+}
+
+// snakeToCamel turns "reply_to_message_id" into "replyToMessageId".
+func snakeToCamel(s string) string {
+	if !strings.Contains(s, "_") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	upper := false
+	for _, r := range s {
+		switch {
+		case r == '_':
+			upper = b.Len() > 0
+		case upper:
+			b.WriteString(strings.ToUpper(string(r)))
+			upper = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// marshal encodes body for em with the Serializer or Codec and records the
+// content type to send it with.
+func (e *Emitter) marshal(em *emission, body any) ([]byte, error) {
+	if payload, ok := body.(MessagePayload); ok && e.Serializer != nil {
+		data, contentType, err := e.Serializer.Marshal(payload)
+		em.contentType = contentType
+		return data, err
+	}
+	codec := e.codec()
+	em.contentType = codec.ContentType()
+	return codec.Marshal(body)
+}
+
+func (e *Emitter) codec() Codec {
+	if e.Codec != nil {
+		return e.Codec
+	}
+	return JSONCodec{}
+}
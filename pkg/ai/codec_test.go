@@ -0,0 +1,52 @@
+package ai_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+func TestJSONCodecNaming(t *testing.T) {
+	payload := ai.MessagePayload{
+		SchemaVersion:    ai.SchemaVersion,
+		EventType:        ai.EventMessage,
+		Platform:         ai.PlatformWhatsApp,
+		RoomID:           "!room",
+		Sender:           "@alice",
+		Timestamp:        1700000000000,
+		Text:             "hi",
+		MessageID:        "msg1",
+		Attachments:      []ai.Attachment{{Type: ai.AttachmentImage, MimeType: "image/png"}},
+		ReplyToMessageID: "msg0",
+	}
+	// Bump this along with the fixtures whenever the wire format changes.
+	// Version 4 dropped the always-present "sender_name":"" and
+	// "is_group":false, which this payload leaves empty.
+	const version = "4"
+	if fmt.Sprint(ai.SchemaVersion) != version {
+		t.Fatalf("SchemaVersion is %d, but the fixtures are for version %s", ai.SchemaVersion, version)
+	}
+	cases := []struct {
+		naming ai.FieldNaming
+		golden string
+	}{{
+		ai.NamingSnake,
+		`{"schema_version":` + version + `,"event_type":"message","platform":"whatsapp","room_id":"!room",` +
+			`"sender":"@alice","timestamp":1700000000000,"text":"hi","message_id":"msg1",` +
+			`"attachments":[{"type":"image","mime_type":"image/png"}],"reply_to_message_id":"msg0"}`,
+	}, {
+		ai.NamingCamel,
+		`{"schemaVersion":` + version + `,"eventType":"message","platform":"whatsapp","roomId":"!room",` +
+			`"sender":"@alice","timestamp":1700000000000,"text":"hi","messageId":"msg1",` +
+			`"attachments":[{"type":"image","mimeType":"image/png"}],"replyToMessageId":"msg0"}`,
+	}}
+	for _, c := range cases {
+		data, err := ai.JSONCodec{Naming: c.naming}.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(data) != c.golden {
+			t.Errorf("naming %d encoded\n%s\nwant\n%s", c.naming, data, c.golden)
+		}
+	}
+}
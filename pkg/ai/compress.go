@@ -0,0 +1,31 @@
+package ai
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+)
+
+// DefaultCompressThreshold is the body size above which payloads are gzipped
+// when Emitter.Compress is set and CompressThreshold is zero.
+const DefaultCompressThreshold = 1024
+
+// compress gzips data if compression is enabled and data is over the
+// threshold. Small bodies are returned unchanged.
+func (e *Emitter) compress(data []byte) ([]byte, bool, error) {
+	threshold := e.CompressThreshold
+	if threshold <= 0 {
+		threshold = DefaultCompressThreshold
+	}
+	if !e.Compress || len(data) <= threshold {
+		return data, false, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, false, fmt.Errorf("ai: gzip payload: %w", err)
+	} else if err = zw.Close(); err != nil {
+		return nil, false, fmt.Errorf("ai: gzip payload: %w", err)
+	}
+	return buf.Bytes(), true, nil
+}
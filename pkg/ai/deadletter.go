@@ -0,0 +1,283 @@
+package ai
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DeadLetterSink stores messages the emitter failed to deliver so that they
+// can be reprocessed later.
+type DeadLetterSink interface {
+	Store(payload MessagePayload, err error) error
+}
+
+// DeadLetterSource gives Replay access to stored dead letters.
+type DeadLetterSource interface {
+	// DeadLetters returns the stored dead letters, oldest first.
+	DeadLetters() ([]DeadLetter, error)
+	// Remove deletes the dead letters with the given IDs. IDs that are no
+	// longer stored, e.g. because the letters were rotated out in the
+	// meantime, are ignored.
+	Remove(ids []string) error
+}
+
+// DeadLetter is a single failed message as written by FileDeadLetterSink.
+// FailedAt is in Unix milliseconds.
+type DeadLetter struct {
+	// ID identifies the letter for DeadLetterSource.Remove. It stays the
+	// same however the letters around it are stored, removed or rotated.
+	ID       string         `json:"id"`
+	Payload  MessagePayload `json:"payload"`
+	Error    string         `json:"error"`
+	FailedAt int64          `json:"failed_at"`
+}
+
+// deadLetter stores payload if err means the sidecar couldn't be reached or
+// kept failing, so that replaying it later can succeed.
+func (e *Emitter) deadLetter(ctx context.Context, payload MessagePayload, err error) {
+	if e.DeadLetters == nil || ctx.Err() != nil || !isRetryable(err) {
+		return
+	}
+	if storeErr := e.DeadLetters.Store(payload, err); storeErr != nil {
+		e.logger().Error("Failed to store dead letter",
+			slog.String("platform", payload.Platform),
+			slog.String("message_id", payload.MessageID),
+			slog.Any("error", storeErr),
+		)
+	}
+}
+
+// Replay calls Emitter.Replay on the package-level emitter.
+func Replay(ctx context.Context, source DeadLetterSource) (replayed, stillFailed int, err error) {
+	return loadDefault().Replay(ctx, source)
+}
+
+// Replay emits the dead letters in source again, in order, and removes the
+// ones that are delivered. Edits are sent to the edit endpoint again. Each one goes through the rate limiter, breaker
+// and retries like a new message, but is not passed to the interceptors
+// again, and failures are not stored as new dead letters. Replay stops when
+// ctx ends and returns ctx.Err(); stillFailed then includes the letters that
+// were not attempted.
+func (e *Emitter) Replay(ctx context.Context, source DeadLetterSource) (replayed, stillFailed int, err error) {
+	letters, err := source.DeadLetters()
+	if err != nil {
+		return 0, 0, err
+	}
+	var delivered []string
+	for _, letter := range letters {
+		if err = ctx.Err(); err != nil {
+			break
+		} else if e.replay(ctx, letter.Payload) == nil {
+			delivered = append(delivered, letter.ID)
+		}
+	}
+	if len(delivered) > 0 {
+		if removeErr := source.Remove(delivered); removeErr != nil && err == nil {
+			err = removeErr
+		}
+	}
+	replayed, stillFailed = len(delivered), len(letters)-len(delivered)
+	e.logger().Info("Replayed dead letters",
+		slog.Int("replayed", replayed),
+		slog.Int("still_failed", stillFailed),
+	)
+	return replayed, stillFailed, err
+}
+
+// replay sends a dead letter to the endpoint it originally failed at.
+func (e *Emitter) replay(ctx context.Context, payload MessagePayload) error {
+	if payload.EventType == EventEdit {
+		return e.sendEdit(ctx, payload)
+	}
+	return e.sendMessage(ctx, payload)
+}
+
+// FileDeadLetterSink appends dead letters to a file, one JSON object per line.
+// It is also a DeadLetterSource.
+type FileDeadLetterSink struct {
+	// Logger receives failures to rotate the file and corrupt lines skipped
+	// by DeadLetters. It must be set before the sink is used. Defaults to
+	// slog.Default().
+	Logger *slog.Logger
+
+	lock     sync.Mutex
+	path     string
+	file     *os.File
+	size     int64
+	maxBytes int64
+}
+
+// NewFileDeadLetterSink opens path for appending, creating it if needed.
+func NewFileDeadLetterSink(path string) (*FileDeadLetterSink, error) {
+	return NewRotatingFileDeadLetterSink(path, 0)
+}
+
+// NewRotatingFileDeadLetterSink is like NewFileDeadLetterSink, but once the
+// file would grow beyond maxBytes, it is renamed to path + ".1", replacing the
+// previous one, and a new file is started. Only the current file is read by
+// DeadLetters. A non-positive maxBytes disables rotation.
+func NewRotatingFileDeadLetterSink(path string, maxBytes int64) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("ai: open dead letter file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ai: open dead letter file: %w", err)
+	}
+	return &FileDeadLetterSink{path: path, file: file, size: info.Size(), maxBytes: maxBytes}, nil
+}
+
+// Store appends payload and the error that made it fail as one line.
+func (s *FileDeadLetterSink) Store(payload MessagePayload, err error) error {
+	line, jsonErr := json.Marshal(DeadLetter{
+		ID:       randomKey(),
+		Payload:  payload,
+		Error:    err.Error(),
+		FailedAt: time.Now().UnixMilli(),
+	})
+	if jsonErr != nil {
+		return fmt.Errorf("%w: %w", ErrMarshal, jsonErr)
+	}
+	line = append(line, '\n')
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.maxBytes > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxBytes {
+		// If rotating fails, keep writing to the current file rather than
+		// losing the letter.
+		if rotateErr := s.rotate(); rotateErr != nil {
+			s.logger().Warn("Failed to rotate dead letter file",
+				slog.String("path", s.path),
+				slog.Any("error", rotateErr),
+			)
+		}
+	}
+	n, writeErr := s.file.Write(line)
+	s.size += int64(n)
+	return writeErr
+}
+
+func (s *FileDeadLetterSink) logger() *slog.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return slog.Default()
+}
+
+func (s *FileDeadLetterSink) rotate() error {
+	if err := os.Rename(s.path, s.path+".1"); err != nil {
+		return fmt.Errorf("ai: rotate dead letter file: %w", err)
+	}
+	file, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("ai: open dead letter file: %w", err)
+	}
+	s.file.Close()
+	s.file, s.size = file, 0
+	return nil
+}
+
+// Close closes the underlying file.
+func (s *FileDeadLetterSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.file.Close()
+}
+
+// DeadLetters reads back every dead letter in the file. Corrupt lines are
+// skipped, but kept in the file.
+func (s *FileDeadLetterSink) DeadLetters() ([]DeadLetter, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lines, err := s.readLines()
+	if err != nil {
+		return nil, err
+	}
+	letters := make([]DeadLetter, 0, len(lines))
+	for i, line := range lines {
+		letter, err := parseDeadLetter(line)
+		if err != nil {
+			s.logger().Warn("Skipping corrupt dead letter",
+				slog.String("path", s.path),
+				slog.Int("line", i+1),
+				slog.Any("error", err),
+			)
+			continue
+		}
+		letters = append(letters, letter)
+	}
+	return letters, nil
+}
+
+// parseDeadLetter decodes a line of the file. Letters written before they had
+// IDs are identified by a hash of their line instead.
+func parseDeadLetter(line []byte) (DeadLetter, error) {
+	var letter DeadLetter
+	if err := json.Unmarshal(line, &letter); err != nil {
+		return letter, err
+	} else if letter.ID == "" {
+		h := fnv.New64a()
+		h.Write(line)
+		letter.ID = "line-" + strconv.FormatUint(h.Sum64(), 16)
+	}
+	return letter, nil
+}
+
+// Remove rewrites the file without the given dead letters.
+func (s *FileDeadLetterSink) Remove(ids []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	lines, err := s.readLines()
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("ai: create dead letter file: %w", err)
+	}
+	// Corrupt lines are kept, like DeadLetters skips them.
+	var size int64
+	for _, line := range lines {
+		if letter, err := parseDeadLetter(line); err == nil && slices.Contains(ids, letter.ID) {
+			continue
+		}
+		if _, err = file.Write(append(line, '\n')); err != nil {
+			file.Close()
+			return fmt.Errorf("ai: write dead letter file: %w", err)
+		}
+		size += int64(len(line)) + 1
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("ai: write dead letter file: %w", err)
+	} else if err = os.Rename(tmp, s.path); err != nil {
+		file.Close()
+		return fmt.Errorf("ai: replace dead letter file: %w", err)
+	}
+	s.file.Close()
+	s.file, s.size = file, size
+	return nil
+}
+
+func (s *FileDeadLetterSink) readLines() ([][]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("ai: read dead letter file: %w", err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'})
+	if len(lines) == 1 && len(lines[0]) == 0 {
+		return nil, nil
+	}
+	return lines, nil
+}
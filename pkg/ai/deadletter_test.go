@@ -0,0 +1,190 @@
+package ai_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+func TestFallbackFile(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	const maxBytes = 1024
+	var logs bytes.Buffer
+	e, err := ai.New(
+		ai.WithURL(down.URL+"/message"),
+		ai.WithFallbackFile(path, maxBytes),
+		ai.WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := e.DeadLetters.(*ai.FileDeadLetterSink)
+	defer sink.Close()
+
+	const count = 20
+	for i := range count {
+		if err = e.Emit(testPayload(fmt.Sprintf("msg%d", i))); err == nil {
+			t.Fatal("Emit to a downed sidecar succeeded")
+		}
+	}
+	var letters int
+	for _, name := range []string{path + ".1", path} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("file wasn't rotated: %v", err)
+		} else if len(data) > maxBytes {
+			t.Errorf("%s has %d bytes, more than %d", name, len(data), maxBytes)
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var letter ai.DeadLetter
+			if err = json.Unmarshal([]byte(line), &letter); err != nil {
+				t.Fatalf("invalid line in %s: %v", name, err)
+			} else if letter.Payload.MessageID == "" || letter.Error == "" {
+				t.Fatalf("incomplete dead letter %s", line)
+			}
+			letters++
+		}
+	}
+	if letters >= count {
+		t.Fatalf("found all %d letters, want the oldest ones rotated out", letters)
+	}
+	latest, err := sink.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	} else if id := latest[len(latest)-1].Payload.MessageID; id != fmt.Sprintf("msg%d", count-1) {
+		t.Fatalf("last dead letter is %s, want msg%d", id, count-1)
+	}
+
+	// Corrupt lines are reported through the emitter's logger.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("not json\n")
+	file.Close()
+	if _, err = sink.DeadLetters(); err != nil {
+		t.Fatal(err)
+	} else if !strings.Contains(logs.String(), "Skipping corrupt dead letter") {
+		t.Fatalf("corrupt line wasn't logged by the emitter's logger: %s", logs.String())
+	}
+}
+
+func TestDeadLetterRemoveAfterRotation(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	path := filepath.Join(t.TempDir(), "dead.jsonl")
+	e, err := ai.New(ai.WithURL(down.URL+"/message"), ai.WithFallbackFile(path, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := e.DeadLetters.(*ai.FileDeadLetterSink)
+	defer sink.Close()
+
+	e.Emit(testPayload("old0"))
+	e.Emit(testPayload("old1"))
+	read, err := sink.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(read) != 2 || read[0].ID == "" || read[0].ID == read[1].ID {
+		t.Fatalf("got %+v, want two letters with distinct IDs", read)
+	}
+	// Store rotates the file before the letters that were read are removed.
+	for i := 0; ; i++ {
+		e.Emit(testPayload(fmt.Sprintf("new%d", i)))
+		if _, err = os.Stat(path + ".1"); err == nil {
+			break
+		}
+	}
+	before, err := sink.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = sink.Remove([]string{read[0].ID, read[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	after, err := sink.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(after) != len(before) {
+		t.Fatalf("Remove deleted %d letters stored after the rotation", len(before)-len(after))
+	}
+}
+
+func TestDeadLetterOnlyTransportErrors(t *testing.T) {
+	var (
+		lock   sync.Mutex
+		status = http.StatusServiceUnavailable
+		paths  []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		paths = append(paths, r.URL.Path)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+	sink, err := ai.NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	e := &ai.Emitter{URL: srv.URL + "/message", DeadLetters: sink}
+
+	// Replaying these would fail the same way, so they are not stored.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = e.EmitContext(cancelled, testPayload("cancelled")); err == nil {
+		t.Fatal("EmitContext with a cancelled context succeeded")
+	}
+	tooLarge := testPayload("too-large")
+	tooLarge.Text = strings.Repeat("x", ai.DefaultMaxPayloadBytes)
+	if err = e.Emit(tooLarge); err == nil {
+		t.Fatal("Emit of an oversized payload succeeded")
+	}
+	lock.Lock()
+	status = http.StatusBadRequest
+	lock.Unlock()
+	if err = e.Emit(testPayload("rejected")); err == nil {
+		t.Fatal("Emit of a rejected payload succeeded")
+	}
+
+	lock.Lock()
+	status = http.StatusServiceUnavailable
+	lock.Unlock()
+	if err = e.Emit(testPayload("message")); err == nil {
+		t.Fatal("Emit to a failing sidecar succeeded")
+	} else if err = e.EmitEdit(testPayload("edit")); err == nil {
+		t.Fatal("EmitEdit to a failing sidecar succeeded")
+	}
+	letters, err := sink.DeadLetters()
+	if err != nil {
+		t.Fatal(err)
+	} else if len(letters) != 2 || letters[0].Payload.MessageID != "message" || letters[1].Payload.MessageID != "edit" {
+		t.Fatalf("got dead letters %+v, want the message and the edit", letters)
+	}
+
+	lock.Lock()
+	status, paths = http.StatusOK, nil
+	lock.Unlock()
+	if replayed, stillFailed, err := e.Replay(context.Background(), sink); err != nil || replayed != 2 || stillFailed != 0 {
+		t.Fatalf("Replay = %d, %d, %v; want 2, 0, nil", replayed, stillFailed, err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(paths) != 2 || paths[0] != "/message" || paths[1] != "/edit" {
+		t.Fatalf("replayed to %v, want [/message /edit]", paths)
+	}
+}
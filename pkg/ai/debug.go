@@ -0,0 +1,73 @@
+package ai
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"slices"
+	"unicode/utf8"
+)
+
+// logPayload logs em.body at debug level if e.Debug is set. Message text,
+// quoted text, captions and polls are masked, so only their lengths are
+// logged.
+func (e *Emitter) logPayload(em *emission) {
+	if !e.Debug {
+		return
+	}
+	var body any
+	switch v := em.body.(type) {
+	case MessagePayload:
+		body = maskPayload(v)
+	case []MessagePayload:
+		masked := make([]MessagePayload, len(v))
+		for i := range v {
+			masked[i] = maskPayload(v[i])
+		}
+		body = masked
+	default:
+		body = v
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	endpoint := em.endpoint
+	if endpoint == "" {
+		endpoint = "message"
+	}
+	attrs := []any{
+		slog.String("endpoint", endpoint),
+		slog.String("platform", em.platform),
+	}
+	if payload, ok := em.body.(MessagePayload); ok {
+		attrs = append(attrs,
+			slog.String("room_id", payload.RoomID),
+			slog.String("sender", payload.Sender),
+			slog.String("message_id", payload.MessageID),
+		)
+	}
+	attrs = append(attrs, slog.String("payload", string(data)))
+	e.logger().Debug("Emitting payload to sidecar", attrs...)
+}
+
+func maskPayload(payload MessagePayload) MessagePayload {
+	payload.Text = maskText(payload.Text)
+	payload.FormattedText = maskText(payload.FormattedText)
+	payload.QuotedText = maskText(payload.QuotedText)
+	payload.Poll = mapPoll(payload.Poll, maskText)
+	if len(payload.Attachments) > 0 {
+		payload.Attachments = slices.Clone(payload.Attachments)
+		for i := range payload.Attachments {
+			payload.Attachments[i].Caption = maskText(payload.Attachments[i].Caption)
+		}
+	}
+	return payload
+}
+
+func maskText(text string) string {
+	if text == "" {
+		return ""
+	}
+	return fmt.Sprintf("[%d chars]", utf8.RuneCountInString(text))
+}
@@ -0,0 +1,33 @@
+package ai_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestDebugMasksText(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	var logs bytes.Buffer
+	e := rec.Emitter()
+	e.Debug = true
+	e.Logger = slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	payload := testPayload("msg1")
+	payload.Text = "text " + secret
+	payload.QuotedText = "quote " + secret
+	payload.Poll = &ai.PollData{Question: "question " + secret, Options: []string{"option " + secret}}
+	if err := e.Emit(payload); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	if !strings.Contains(logs.String(), "Emitting payload to sidecar") {
+		t.Fatal("payload wasn't logged")
+	} else if strings.Contains(logs.String(), secret) {
+		t.Fatalf("debug log leaks message content: %s", logs.String())
+	}
+}
@@ -0,0 +1,149 @@
+package ai
+
+import (
+	"container/list"
+	"context"
+	"hash/fnv"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultDedupTTL is used by NewDedupEmitter when ttl is not positive.
+	DefaultDedupTTL = 10 * time.Minute
+	// DefaultDedupSize is used by NewDedupEmitter when maxEntries is not positive.
+	DefaultDedupSize = 4096
+)
+
+// DedupEmitter drops payloads whose Platform and MessageID were already
+// emitted successfully within the TTL with the same content. Platforms are
+// compared after normalization, so aliases such as "wa" and "whatsapp" match.
+// A payload that reuses a message ID with different text or attachments, such
+// as an edit, is passed through and replaces the cached content. The cache
+// holds at most maxEntries keys; the least recently emitted keys are evicted
+// first.
+type DedupEmitter struct {
+	next       MessageEmitter
+	ttl        time.Duration
+	maxEntries int
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type dedupEntry struct {
+	key     string
+	hash    uint64
+	expires time.Time
+}
+
+// NewDedupEmitter wraps next with a deduplication cache.
+func NewDedupEmitter(next MessageEmitter, ttl time.Duration, maxEntries int) *DedupEmitter {
+	if ttl <= 0 {
+		ttl = DefaultDedupTTL
+	}
+	if maxEntries <= 0 {
+		maxEntries = DefaultDedupSize
+	}
+	return &DedupEmitter{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+}
+
+func (d *DedupEmitter) Emit(payload MessagePayload) error {
+	return d.EmitContext(context.Background(), payload)
+}
+
+// EmitContext forwards payload unless it is a duplicate, in which case it
+// returns nil without doing anything. The payload is remembered before it is
+// forwarded, so an identical payload emitted concurrently is dropped as well;
+// if forwarding fails, the payload is forgotten again.
+func (d *DedupEmitter) EmitContext(ctx context.Context, payload MessagePayload) error {
+	key := d.platform(payload.Platform) + "\x00" + payload.MessageID
+	hash := contentHash(&payload)
+	undo, ok := d.reserve(key, hash)
+	if !ok {
+		return nil
+	}
+	err := d.next.EmitContext(ctx, payload)
+	if err != nil {
+		undo()
+	}
+	return err
+}
+
+// platform normalizes platform the way the wrapped emitter will, so that
+// aliases of a platform share cache entries.
+func (d *DedupEmitter) platform(platform string) string {
+	if e, ok := d.next.(*Emitter); ok {
+		return e.normalizePlatform(platform)
+	}
+	return NormalizePlatform(platform)
+}
+
+// contentHash hashes the parts of payload that an edit can change.
+func contentHash(payload *MessagePayload) uint64 {
+	h := fnv.New64a()
+	write := func(s string) {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	write(payload.Text)
+	write(payload.FormattedText)
+	write(strconv.FormatInt(payload.EditedAt, 10))
+	for _, att := range payload.Attachments {
+		write(string(att.Type))
+		write(att.URL)
+		write(att.MXC)
+		write(att.Caption)
+	}
+	return h.Sum64()
+}
+
+// reserve remembers hash for key, unless it is already remembered. The
+// returned function restores the previous state, as long as key has not been
+// reserved again in the meantime.
+func (d *DedupEmitter) reserve(key string, hash uint64) (undo func(), ok bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := time.Now()
+	expires := now.Add(d.ttl)
+	if elem, exists := d.entries[key]; exists {
+		entry := elem.Value.(*dedupEntry)
+		if entry.hash == hash && now.Before(entry.expires) {
+			return nil, false
+		}
+		prev := *entry
+		entry.hash, entry.expires = hash, expires
+		d.order.MoveToFront(elem)
+		return func() {
+			d.lock.Lock()
+			defer d.lock.Unlock()
+			if d.entries[key] == elem && entry.hash == hash && entry.expires.Equal(expires) {
+				entry.hash, entry.expires = prev.hash, prev.expires
+			}
+		}, true
+	}
+	entry := &dedupEntry{key: key, hash: hash, expires: expires}
+	elem := d.order.PushFront(entry)
+	d.entries[key] = elem
+	for d.order.Len() > d.maxEntries {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupEntry).key)
+	}
+	return func() {
+		d.lock.Lock()
+		defer d.lock.Unlock()
+		if d.entries[key] == elem && entry.hash == hash && entry.expires.Equal(expires) {
+			d.order.Remove(elem)
+			delete(d.entries, key)
+		}
+	}, true
+}
@@ -0,0 +1,113 @@
+package ai_test
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestDedupMessageID(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	d := ai.NewDedupEmitter(rec.Emitter(), 0, 0)
+
+	for _, id := range []string{"msg1", "msg1", "msg2"} {
+		if err := d.Emit(testPayload(id)); err != nil {
+			t.Fatalf("Emit %s: %v", id, err)
+		}
+	}
+	if n := rec.Requests(); n != 2 {
+		t.Fatalf("got %d requests, want 2", n)
+	}
+	received := rec.Received()
+	if received[0].MessageID != "msg1" || received[1].MessageID != "msg2" {
+		t.Fatalf("got messages %s and %s, want msg1 and msg2", received[0].MessageID, received[1].MessageID)
+	}
+}
+
+func TestDedupContent(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	d := ai.NewDedupEmitter(rec.Emitter(), 0, 0)
+
+	original := testPayload("msg1")
+	original.Text = "hello"
+	edited := original
+	edited.Text = "hello, world"
+	other := testPayload("msg2")
+	other.Text = "hello"
+	cases := []struct {
+		name    string
+		payload ai.MessagePayload
+		sent    bool
+	}{
+		{"first", original, true},
+		{"same ID and content", original, false},
+		{"same ID, new content", edited, true},
+		{"new ID", other, true},
+	}
+	for _, c := range cases {
+		before := rec.Requests()
+		if err := d.Emit(c.payload); err != nil {
+			t.Fatalf("%s: Emit: %v", c.name, err)
+		} else if sent := rec.Requests() > before; sent != c.sent {
+			t.Errorf("%s: sent = %v, want %v", c.name, sent, c.sent)
+		}
+	}
+}
+
+func TestDedupConcurrent(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	d := ai.NewDedupEmitter(rec.Emitter(), 0, 0)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := d.Emit(testPayload("msg1")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := rec.Requests(); n != 1 {
+		t.Fatalf("got %d requests for identical concurrent payloads, want 1", n)
+	}
+}
+
+func TestDedupForgetsFailures(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	d := ai.NewDedupEmitter(rec.Emitter(), 0, 0)
+
+	rec.FailNext(1, aitest.Fault{StatusCode: http.StatusBadRequest})
+	if err := d.Emit(testPayload("msg1")); err == nil {
+		t.Fatal("Emit succeeded despite the fault")
+	} else if err = d.Emit(testPayload("msg1")); err != nil {
+		t.Fatalf("Emit after failure: %v", err)
+	} else if len(rec.Received()) != 1 {
+		t.Fatal("payload wasn't retried after the failed emit")
+	}
+}
+
+func TestDedupPlatformAliases(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	d := ai.NewDedupEmitter(rec.Emitter(), 0, 0)
+
+	for _, platform := range []string{"whatsapp", "WA", " WhatsApp "} {
+		payload := testPayload("msg1")
+		payload.Platform = platform
+		if err := d.Emit(payload); err != nil {
+			t.Fatalf("Emit %q: %v", platform, err)
+		}
+	}
+	if n := rec.Requests(); n != 1 {
+		t.Fatalf("got %d requests for aliases of one platform, want 1", n)
+	}
+}
@@ -0,0 +1,62 @@
+package ai_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+// TestDefaultConcurrentReconfigure emits through the package-level emitter
+// while it is being reconfigured. Run it with -race.
+func TestDefaultConcurrentReconfigure(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	if err := ai.Configure(rec.MessageURL()); err != nil {
+		t.Fatal(err)
+	}
+	defer ai.SetDefault(nil)
+
+	const workers, perWorker = 50, 40
+	stop := make(chan struct{})
+	var reconfigured sync.WaitGroup
+	reconfigured.Add(1)
+	go func() {
+		defer reconfigured.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			ai.SetAuthToken(fmt.Sprintf("token%d", i))
+			ai.SetHeader("X-Generation", fmt.Sprint(i))
+			if err := ai.Configure(rec.MessageURL()); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	var emitters sync.WaitGroup
+	for w := range workers {
+		emitters.Add(1)
+		go func() {
+			defer emitters.Done()
+			for i := range perWorker {
+				if err := ai.Emit(testPayload(fmt.Sprintf("msg%d-%d", w, i))); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	emitters.Wait()
+	close(stop)
+	reconfigured.Wait()
+	if n := len(rec.Received()); n != workers*perWorker {
+		t.Fatalf("received %d messages, want %d", n, workers*perWorker)
+	}
+}
@@ -2,56 +2,824 @@ package ai
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
-type MessagePayload struct {
-	Platform    string `json:"platform"`
-	RoomID      string `json:"room_id"`
-	Sender      string `json:"sender"`
-	SenderName  string `json:"sender_name"`
-	IsGroup     bool   `json:"is_group"`
-	Timestamp   int64  `json:"timestamp"`
-	Text        string `json:"text"`
-	MessageID   string `json:"message_id"`
+// DefaultSidecarURL is used until Configure is called.
+const DefaultSidecarURL = "http://localhost:8080/message"
+
+// DefaultHealthPath is used by Ping when Emitter.HealthPath is empty.
+const DefaultHealthPath = "/health"
+
+// DefaultMaxQuotedText is used when Emitter.MaxQuotedText is zero.
+const DefaultMaxQuotedText = 500
+
+// DefaultTimeout and DefaultReceiveTimeout are used when Emitter.Timeout is
+// zero.
+const (
+	DefaultTimeout        = 2 * time.Second
+	DefaultReceiveTimeout = 30 * time.Second
+)
+
+// EnvSidecarURL is the environment variable read by ConfigureFromEnv.
+const EnvSidecarURL = "NEXA_SIDECAR_URL"
+
+// MessageEmitter is implemented by Emitter and by the wrappers in this
+// package, so they can be stacked.
+type MessageEmitter interface {
+	EmitContext(ctx context.Context, payload MessagePayload) error
+}
+
+// Emitter posts message payloads to a sidecar. Build one with New or
+// NewEmitter, or set the fields of a zero Emitter directly.
+//
+// An Emitter is safe for concurrent use by multiple goroutines, and all its
+// requests share one http.Client so connections are reused. Its fields must
+// not be changed once it is in use; build a new Emitter instead. The
+// package-level setters such as Configure and SetAuthToken may be called at
+// any time: they replace the package-level emitter with an updated copy, so
+// emissions already in flight finish with the old settings.
+type Emitter struct {
+	URL string
+	// Endpoints, if set, replaces URL with several http:// or https:// message
+	// URLs, which are used according to Strategy.
+	Endpoints []string
+	// Strategy decides how requests are spread over Endpoints.
+	Strategy EndpointStrategy
+	// PlatformAliases maps lowercase platform names to the canonical names
+	// sent to the sidecar, which also select PlatformRoutes and metrics
+	// labels. Platforms not in it are sent unchanged. Defaults to
+	// DefaultPlatformAliases; clone that to extend it.
+	PlatformAliases map[string]string
+	// PlatformRoutes maps payload platforms to the http:// or https://
+	// message URL of a sidecar dedicated to them. Other platforms use URL or
+	// Endpoints. Keys should be canonical platform names such as
+	// PlatformTelegram; aliases are normalized like payload platforms.
+	PlatformRoutes map[string]string
+	// Client is used for all requests. If nil, a shared client is used.
+	// A Timeout set on the client applies on top of Timeout. With a unix://
+	// URL, a copy of its transport dials the socket instead, so it must be
+	// an *http.Transport.
+	Client *http.Client
+	// Timeout limits each request to the sidecar. Zero means DefaultTimeout,
+	// or DefaultReceiveTimeout for EmitAndReceive, which waits for the
+	// sidecar to generate a reply. It is not possible to disable it.
+	Timeout time.Duration
+	// MaxRetries is the number of extra attempts made after a connection
+	// error or a 5xx response. 4xx responses are never retried.
+	MaxRetries int
+	// BaseBackoff is the delay before the first retry. It doubles on each
+	// subsequent retry. Defaults to DefaultBaseBackoff.
+	BaseBackoff time.Duration
+	// AuthToken is sent as a bearer token in the Authorization header.
+	// No header is sent if it is empty.
+	AuthToken string
+	// Headers are added to every request. A Content-Type set here replaces
+	// the one given by Codec or Serializer.
+	Headers http.Header
+	// SigningKey enables HMAC-SHA256 request signing when set. See
+	// SignatureHeader for the scheme.
+	SigningKey []byte
+	// Codec encodes request bodies. Defaults to JSONCodec with snake_case
+	// keys; set JSONCodec{Naming: NamingCamel} for camelCase.
+	Codec Codec
+	// Serializer, if set, replaces Codec for single messages and edits.
+	Serializer Serializer
+	// MaxPayloadBytes limits the size of encoded bodies, before compression.
+	// Zero means DefaultMaxPayloadBytes and a negative value disables the
+	// limit. OversizePolicy decides what happens to larger bodies.
+	MaxPayloadBytes int
+	OversizePolicy  OversizePolicy
+	// Capabilities, if set, limits the event types that are sent; emissions
+	// of other types are dropped without error. See FetchCapabilities. Nil
+	// sends everything.
+	Capabilities *Capabilities
+	// HealthPath is requested by Ping. A relative path is resolved next to
+	// the message endpoint, an absolute one from the host root. Defaults to
+	// DefaultHealthPath.
+	HealthPath string
+	// Metrics receives emission outcomes and request latencies. Nothing is
+	// recorded if it is nil.
+	Metrics Metrics
+	// Tracer, if set, wraps every emission in a span and propagates its
+	// trace context to the sidecar.
+	Tracer Tracer
+	// Compress enables gzip for request bodies larger than
+	// CompressThreshold bytes (DefaultCompressThreshold if zero). Only enable
+	// it if the sidecar accepts Content-Encoding: gzip.
+	Compress          bool
+	CompressThreshold int
+	// Logger receives diagnostic messages. Defaults to slog.Default(); use
+	// DiscardLogger to silence the emitter.
+	Logger *slog.Logger
+	// Debug logs every outgoing payload at debug level, with message text,
+	// quoted text and captions masked. Leave it off in production.
+	Debug bool
+	// RateLimiter, if set, throttles emissions before they are sent.
+	// Retries of an emission do not take extra tokens.
+	RateLimiter *RateLimiter
+	// Breaker, if set, fails emissions fast with ErrCircuitOpen while the
+	// sidecar is considered down.
+	Breaker *CircuitBreaker
+	// TypingInterval is how long EmitTyping suppresses repeats of the same
+	// typing state in a room. Zero means DefaultTypingInterval.
+	TypingInterval time.Duration
+	// PresenceInterval is the same for EmitPresence and a user's status.
+	// Zero means DefaultPresenceInterval.
+	PresenceInterval time.Duration
+	// MaxQuotedText is the number of runes of MessagePayload.QuotedText
+	// that are sent. Zero means DefaultMaxQuotedText and a negative value
+	// disables the limit.
+	MaxQuotedText int
+	// RFC3339Time adds the time fields to payloads, repeating their Unix
+	// millisecond timestamps as RFC 3339 strings.
+	RFC3339Time bool
+	// LanguageDetector, if set, fills in MessagePayload.Language when the
+	// bridge left it empty.
+	LanguageDetector LanguageDetector
+	// Redactor, if set, scrubs message text and captions before they are
+	// encoded.
+	Redactor Redactor
+	// Interceptors run in order on every message, edit and batched message
+	// after filtering, language detection and redaction, and right before it
+	// is encoded; each one sees the output of the previous one. See
+	// Interceptor for how to abort or drop a message.
+	Interceptors []Interceptor
+	// SelfID is the bridge's own user ID. Payloads sent by it, or by any of
+	// IgnoreSenders, are dropped without error before any request is made.
+	SelfID        string
+	IgnoreSenders []string
+	// DeadLetters, if set, receives messages and edits that could not be
+	// delivered because the sidecar was still unavailable or failing when
+	// the retries ran out. Errors that a replay wouldn't fix, such as
+	// ErrPayloadTooLarge, ErrCircuitOpen or a cancelled context, are only
+	// returned.
+	DeadLetters DeadLetterSink
+
+	// socket is set through a unix:// URL.
+	socket *unixSocket
+
+	// recent is guarded by the package-level throttleLock.
+	recent map[string]recentState
+}
+
+// DiscardLogger drops everything logged to it.
+var DiscardLogger = slog.New(slog.DiscardHandler)
+
+// defaultIdleConnsPerHost raises http.DefaultTransport's limit of 2, as all
+// requests usually go to a single sidecar.
+const defaultIdleConnsPerHost = 32
+
+var defaultClient = &http.Client{
+	Transport: newDefaultTransport(),
+}
+
+func newDefaultTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = defaultIdleConnsPerHost
+	return t
+}
+
+// defaultEmitter is created on first use by loadDefault.
+var defaultEmitter atomic.Pointer[Emitter]
+
+func loadDefault() *Emitter {
+	if e := defaultEmitter.Load(); e != nil {
+		return e
+	}
+	defaultEmitter.CompareAndSwap(nil, &Emitter{URL: DefaultSidecarURL})
+	return defaultEmitter.Load()
+}
+
+// SetDefault replaces the package-level emitter with e, for example one built
+// with New. The package-level setters then update copies of e. Passing nil
+// restores an emitter with default settings.
+func SetDefault(e *Emitter) {
+	defaultEmitter.Store(e)
+}
+
+// updateDefault applies fn to a copy of the package-level emitter and swaps
+// the copy in. If fn fails, the package-level emitter is left unchanged.
+func updateDefault(fn func(e *Emitter) error) error {
+	for {
+		old := loadDefault()
+		e := old.clone()
+		if err := fn(e); err != nil {
+			return err
+		} else if defaultEmitter.CompareAndSwap(old, e) {
+			return nil
+		}
+	}
+}
+
+func (e *Emitter) clone() *Emitter {
+	throttleLock.Lock()
+	c := *e
+	throttleLock.Unlock()
+	c.Headers = e.Headers.Clone()
+	c.PlatformRoutes = maps.Clone(e.PlatformRoutes)
+	return &c
+}
+
+// NewEmitter returns an Emitter for the given sidecar URL. See Configure for
+// the accepted formats.
+func NewEmitter(rawURL string) (*Emitter, error) {
+	e := &Emitter{}
+	if err := e.setURL(rawURL); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Configure changes the sidecar URL used by the package-level Emit.
+//
+// The URL is either an http:// or https:// URL of the message endpoint, or
+// a unix:// URL of a socket path such as unix:///var/run/nexa-sidecar.sock,
+// in which case requests are sent over that socket to /message.
+func Configure(rawURL string) error {
+	return updateDefault(func(e *Emitter) error {
+		return e.setURL(rawURL)
+	})
+}
+
+// ConfigureBase is like Configure, but takes the base URL the sidecar's
+// endpoints are mounted under, such as http://host:8080/api/v1. See
+// MessageURL.
+func ConfigureBase(rawBase string) error {
+	messageURL, err := MessageURL(rawBase)
+	if err != nil {
+		return err
+	}
+	return Configure(messageURL)
+}
+
+// MessageURL returns the message endpoint of a sidecar whose endpoints are
+// mounted under the http:// or https:// URL base, so that both
+// "http://host/api/v1" and "http://host/api/v1/" give
+// "http://host/api/v1/message". The other endpoints, such as /reaction, are
+// then found next to it. The result can be used anywhere a message URL is
+// expected, including Emitter.Endpoints and Emitter.PlatformRoutes.
+func MessageURL(base string) (string, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("ai: invalid sidecar URL: %w", err)
+	} else if err = checkHTTPURL(u); err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimRight(u.Path, "/") + "/message"
+	u.RawPath = ""
+	return u.String(), nil
+}
+
+// ConfigureFromEnv calls Configure with the value of NEXA_SIDECAR_URL.
+// It does nothing if the variable is unset.
+func ConfigureFromEnv() error {
+	rawURL, ok := os.LookupEnv(EnvSidecarURL)
+	if !ok {
+		return nil
+	}
+	return Configure(rawURL)
+}
+
+// SetAuthToken changes the bearer token used by the package-level Emit.
+func SetAuthToken(token string) {
+	updateDefault(func(e *Emitter) error {
+		e.AuthToken = token
+		return nil
+	})
+}
+
+// SetHeader sets a header sent on every request of the package-level emitter.
+func SetHeader(key, value string) {
+	updateDefault(func(e *Emitter) error {
+		if e.Headers == nil {
+			e.Headers = make(http.Header)
+		}
+		e.Headers.Set(key, value)
+		return nil
+	})
+}
+
+// SetLogger changes the logger used by the package-level emitter.
+func SetLogger(logger *slog.Logger) {
+	updateDefault(func(e *Emitter) error {
+		e.Logger = logger
+		return nil
+	})
+}
+
+// SetHTTPClient changes the client used by the package-level Emit.
+// Passing nil restores the shared default client.
+func SetHTTPClient(client *http.Client) {
+	updateDefault(func(e *Emitter) error {
+		e.Client = client
+		return nil
+	})
+}
+
+func (e *Emitter) setURL(rawURL string) error {
+	if rawURL == "" {
+		return errors.New("ai: sidecar URL is empty")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("ai: invalid sidecar URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if err = checkHTTPURL(u); err != nil {
+			return err
+		}
+		e.URL = rawURL
+		e.socket = nil
+	case "unix":
+		if u.Path == "" {
+			return fmt.Errorf("ai: sidecar URL %q has no socket path", rawURL)
+		}
+		e.URL = unixMessageURL
+		e.socket = newUnixSocket(u.Path)
+	default:
+		return fmt.Errorf("ai: unsupported sidecar URL scheme %q", u.Scheme)
+	}
+	return nil
+}
+
+func checkHTTPURL(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("ai: unsupported sidecar URL scheme %q", u.Scheme)
+	} else if u.Host == "" {
+		return fmt.Errorf("ai: sidecar URL %q has no host", u.String())
+	}
+	return nil
+}
+
+// SetPlatformRoute makes the package-level emitter send payloads of platform
+// to the sidecar at rawURL, which must be an http:// or https:// message URL.
+// platform is normalized first, so an alias replaces the route of its
+// canonical platform. An empty rawURL removes the route.
+func SetPlatformRoute(platform, rawURL string) error {
+	if rawURL != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return fmt.Errorf("ai: invalid sidecar URL: %w", err)
+		} else if err = checkHTTPURL(u); err != nil {
+			return err
+		}
+	}
+	return updateDefault(func(e *Emitter) error {
+		platform := e.normalizePlatform(platform)
+		if rawURL == "" {
+			delete(e.PlatformRoutes, platform)
+			return nil
+		} else if e.PlatformRoutes == nil {
+			e.PlatformRoutes = make(map[string]string)
+		}
+		e.PlatformRoutes[platform] = rawURL
+		return nil
+	})
+}
+
+var (
+	// ErrSidecarUnavailable is returned when the sidecar could not be reached.
+	ErrSidecarUnavailable = errors.New("ai: sidecar unavailable")
+	// ErrMarshal is returned when a payload could not be serialized.
+	ErrMarshal = errors.New("ai: failed to marshal payload")
+)
+
+// maxErrorBody is the number of bytes of a non-200 response body kept in a
+// StatusError.
+const maxErrorBody = 1024
+
+// StatusError is returned when the sidecar responds with a non-200 status.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	// Body is the start of the response body, cut to 1 KiB.
+	Body string
+	// Message is the "error" field of a JSON response body, if any.
+	Message string
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	statusErr := &StatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody+1))
+	var parsed struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal(body, &parsed) == nil {
+		statusErr.Message = parsed.Error
+	}
+	if len(body) > maxErrorBody {
+		body = append(body[:maxErrorBody], "…"...)
+	}
+	statusErr.Body = strings.ToValidUTF8(strings.TrimSpace(string(body)), "")
+	return statusErr
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return "ai: sidecar returned " + e.Status + ": " + e.Message
+	} else if e.Body != "" {
+		return "ai: sidecar returned " + e.Status + ": " + strconv.Quote(e.Body)
+	}
+	return "ai: sidecar returned " + e.Status
+}
+
+func Emit(payload MessagePayload) error {
+	return loadDefault().Emit(payload)
+}
+
+// EmitContext is like Emit, but the request is bound to ctx.
+func EmitContext(ctx context.Context, payload MessagePayload) error {
+	return loadDefault().EmitContext(ctx, payload)
+}
+
+func (e *Emitter) client() (*http.Client, error) {
+	client := e.Client
+	if client == nil {
+		client = defaultClient
+	}
+	if e.socket != nil {
+		return e.socket.clientFor(client)
+	}
+	return client, nil
+}
+
+// prepare fills in the fields of payload that are set by the emitter.
+func (e *Emitter) prepare(payload *MessagePayload, eventType EventType) {
+	payload.SchemaVersion, payload.EventType = SchemaVersion, eventType
+	payload.Time = e.formatTime(payload.Timestamp)
+	payload.QuotedText = truncateRunes(payload.QuotedText, e.MaxQuotedText, DefaultMaxQuotedText)
+	if payload.CorrelationID == "" {
+		payload.CorrelationID = randomKey()
+	}
+	if payload.Language == "" && payload.Text != "" && e.LanguageDetector != nil {
+		payload.Language = e.LanguageDetector.DetectLanguage(payload.Text)
+	}
+	e.redact(payload)
 }
 
-var sidecarURL = "http://localhost:8080/message"
+// truncateRunes shortens s to limit runes, ending it with an ellipsis.
+// A zero limit means def and a negative one means no limit.
+func truncateRunes(s string, limit, def int) string {
+	if limit == 0 {
+		limit = def
+	}
+	if limit < 0 || utf8.RuneCountInString(s) <= limit {
+		return s
+	} else if limit == 0 {
+		return ""
+	}
+	runes := []rune(s)
+	return string(runes[:limit-1]) + "…"
+}
+
+func (e *Emitter) formatTime(ms int64) string {
+	if !e.RFC3339Time || ms == 0 {
+		return ""
+	}
+	return time.UnixMilli(ms).UTC().Format(time.RFC3339Nano)
+}
+
+func (e *Emitter) timeout(em *emission) time.Duration {
+	if e.Timeout > 0 {
+		return e.Timeout
+	} else if em != nil && em.response != nil {
+		return DefaultReceiveTimeout
+	}
+	return DefaultTimeout
+}
+
+func (e *Emitter) logger() *slog.Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return slog.Default()
+}
+
+func (e *Emitter) Emit(payload MessagePayload) error {
+	return e.EmitContext(context.Background(), payload)
+}
+
+// EmitContext posts payload to the sidecar. Invalid payloads are rejected
+// with ErrInvalidPayload without making a request. If ctx is cancelled or
+// its deadline expires, ctx.Err() is returned.
+func (e *Emitter) EmitContext(ctx context.Context, payload MessagePayload) error {
+	return e.emit(ctx, payload, false)
+}
+
+// prepareMessage runs the steps every message goes through before it is
+// encoded: platform normalization, validation, sender filters, prepare and
+// the interceptors. It reports false if the message must not be sent, along
+// with the error to return, if any.
+func (e *Emitter) prepareMessage(payload *MessagePayload) (bool, error) {
+	payload.Platform = e.normalizePlatform(payload.Platform)
+	if err := payload.Validate(); err != nil {
+		return false, err
+	} else if e.filtered(payload.Platform, payload.Sender) {
+		return false, nil
+	}
+	e.prepare(payload, EventMessage)
+	return e.intercept(payload)
+}
+
+// EncodeMessage runs payload through the same steps as EmitContext up to the
+// point where it would be posted: it is normalized, validated, filtered,
+// redacted, intercepted, encoded with the Serializer or Codec and checked
+// against MaxPayloadBytes. It returns a nil body and no error if the message
+// was filtered or dropped and must not be sent. Other transports, such as
+// aiws, use it to apply the emitter's settings; its URL and HTTP settings
+// are ignored then.
+func (e *Emitter) EncodeMessage(payload MessagePayload) (body []byte, contentType string, err error) {
+	if ok, err := e.prepareMessage(&payload); !ok {
+		return nil, "", err
+	}
+	em := emission{platform: payload.Platform, body: payload}
+	e.logPayload(&em)
+	data, err := e.marshal(&em, payload)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %w", ErrMarshal, err)
+	}
+	if data, err = e.fit(&em, data); err != nil {
+		return nil, "", err
+	}
+	return data, em.contentType, nil
+}
+
+func (e *Emitter) emit(ctx context.Context, payload MessagePayload, confirm bool) error {
+	if ok, err := e.prepareMessage(&payload); !ok {
+		return err
+	}
+	em := emission{
+		platform:       payload.Platform,
+		idempotencyKey: messageKey(&payload),
+		body:           payload,
+	}
+	if confirm {
+		em.ack = payload.MessageID
+	}
+	err := e.send(ctx, em)
+	if err != nil {
+		e.deadLetter(ctx, payload, err)
+	}
+	return err
+}
+
+// sendMessage posts a prepared payload to the message endpoint.
+func (e *Emitter) sendMessage(ctx context.Context, payload MessagePayload) error {
+	return e.send(ctx, emission{
+		platform:       payload.Platform,
+		idempotencyKey: messageKey(&payload),
+		body:           payload,
+	})
+}
+
+// EmitAndReceive calls Emitter.EmitAndReceive on the package-level emitter.
+func EmitAndReceive(ctx context.Context, payload MessagePayload) (*Response, error) {
+	return loadDefault().EmitAndReceive(ctx, payload)
+}
 
-func Emit(payload MessagePayload) {
-	data, err := json.Marshal(payload)
+// EmitAndReceive is like EmitContext, but also decodes the sidecar's JSON
+// response, which may contain a reply for the bridge to send back.
+func (e *Emitter) EmitAndReceive(ctx context.Context, payload MessagePayload) (*Response, error) {
+	if ok, err := e.prepareMessage(&payload); err != nil {
+		return nil, err
+	} else if !ok {
+		return &Response{}, nil
+	}
+	var resp Response
+	err := e.send(ctx, emission{
+		platform:       payload.Platform,
+		idempotencyKey: messageKey(&payload),
+		body:           payload,
+		response:       &resp,
+	})
 	if err != nil {
-		log.Println("[AI] marshal error:", err)
-		return
+		e.deadLetter(ctx, payload, err)
+		return nil, err
+	} else if resp.CorrelationID == "" {
+		resp.CorrelationID = payload.CorrelationID
+	}
+	return &resp, nil
+}
+
+// emission is a single payload on its way to the sidecar.
+type emission struct {
+	platform string
+	// endpoint is the name of a sibling endpoint of the message URL, or
+	// empty for the message URL itself.
+	endpoint string
+	// idempotencyKey is sent unchanged on every attempt. A random key is
+	// generated if it is empty.
+	idempotencyKey string
+	body           any
+	// contentType is set when body is encoded.
+	contentType string
+	// response, if not nil, receives the decoded JSON response body.
+	response any
+	// ack, if set, is the message ID the sidecar must acknowledge. See
+	// EmitConfirmed.
+	ack string
+	// bestEffort emissions are attempted once and never retried.
+	bestEffort bool
+	// span is set while the emission is being traced.
+	span Span
+}
+
+func (e *Emitter) send(ctx context.Context, em emission) error {
+	if !e.supports(&em) {
+		return nil
+	}
+	if e.Tracer != nil {
+		ctx, em.span = e.Tracer.StartEmit(ctx, em.spanInfo())
+	}
+	err := e.deliver(ctx, em)
+	if em.span != nil {
+		em.span.End(err)
 	}
+	if e.Metrics != nil {
+		e.Metrics.EmitDone(em.platform, err)
+	}
+	return err
+}
 
-	req, err := http.NewRequest(
-		"POST",
-		sidecarURL,
-		bytes.NewBuffer(data),
-	)
+func (e *Emitter) deliver(ctx context.Context, em emission) error {
+	targets, err := e.targets(em.platform, em.endpoint)
+	if err != nil {
+		return err
+	}
+	if em.idempotencyKey == "" {
+		em.idempotencyKey = randomKey()
+	}
+	if e.RateLimiter != nil {
+		if err := e.RateLimiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	e.logPayload(&em)
+	data, err := e.marshal(&em, em.body)
 	if err != nil {
-		log.Println("[AI] request error:", err)
-		return
+		return fmt.Errorf("%w: %w", ErrMarshal, err)
+	}
+	if data, err = e.fit(&em, data); err != nil {
+		return err
+	}
+	data, gzipped, err := e.compress(data)
+	if err != nil {
+		return err
+	}
+	attempt := func() error {
+		if em.bestEffort {
+			return e.postAny(ctx, em, targets, data, gzipped)
+		}
+		return e.retry(ctx, func() error {
+			return e.postAny(ctx, em, targets, data, gzipped)
+		})
+	}
+	if e.Breaker == nil {
+		return attempt()
+	} else if err = e.Breaker.allow(); err != nil {
+		return err
 	}
+	err = attempt()
+	if ctx.Err() != nil {
+		e.Breaker.cancel()
+	} else {
+		e.Breaker.record(err)
+	}
+	return err
+}
 
-	req.Header.Set("Content-Type", "application/json")
+// endpoint returns the URL of a sibling endpoint of the primary message URL.
+func (e *Emitter) endpoint(name string) (string, error) {
+	if len(e.Endpoints) > 0 {
+		return resolve(e.Endpoints[0], name)
+	}
+	return resolve(e.URL, name)
+}
 
-	client := &http.Client{
-		Timeout: 2 * time.Second,
+// resolve returns the URL of a sibling endpoint of a message URL, so that
+// "http://host/api/message" maps name "messages" to "http://host/api/messages".
+func resolve(messageURL, name string) (string, error) {
+	base, err := url.Parse(messageURL)
+	if err != nil {
+		return "", fmt.Errorf("ai: invalid sidecar URL: %w", err)
+	}
+	return base.ResolveReference(&url.URL{Path: name}).String(), nil
+}
+
+func (e *Emitter) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("ai: build request: %w", err)
+	}
+	for key, values := range e.Headers {
+		req.Header[key] = slices.Clone(values)
+	}
+	if e.AuthToken != "" {
+		req.Header.Set("Authorization", "Bearer "+e.AuthToken)
 	}
+	return req, nil
+}
 
+// do sends req and returns the status code of the response, or 0 if none was
+// received. ctx is the context of the whole emission: if only the shorter
+// per-request timeout of req expires, that is a transport failure. If ack is
+// set, only an acknowledgement of that message ID is a success.
+func (e *Emitter) do(ctx context.Context, req *http.Request, response any, ack string) (int, error) {
+	client, err := e.client()
+	if err != nil {
+		return 0, err
+	}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("[AI] sidecar unreachable:", err)
-		return
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, fmt.Errorf("%w: %w", ErrSidecarUnavailable, err)
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		log.Println("[AI] sidecar returned", resp.Status)
+	if ack != "" {
+		return resp.StatusCode, checkAck(resp, ack)
+	} else if resp.StatusCode != 200 {
+		return resp.StatusCode, newStatusError(resp)
+	} else if response != nil {
+		if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return resp.StatusCode, fmt.Errorf("ai: decode response: %w", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+func (e *Emitter) post(ctx context.Context, em emission, target string, data []byte, gzipped bool) error {
+	reqCtx, cancel := context.WithTimeout(ctx, e.timeout(&em))
+	defer cancel()
+	req, err := e.newRequest(reqCtx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", em.contentType)
+	}
+	if gzipped {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	req.Header.Set(IdempotencyKeyHeader, em.idempotencyKey)
+	if len(e.SigningKey) > 0 {
+		e.sign(req, data)
+	}
+	if em.span != nil {
+		em.span.Inject(req.Header)
+	}
+	start := time.Now()
+	status, err := e.do(ctx, req, em.response, em.ack)
+	if e.Metrics != nil {
+		e.Metrics.RequestDone(em.platform, time.Since(start))
+	}
+	if em.span != nil {
+		em.span.RequestDone(status)
+	}
+	return err
+}
+
+// Ping calls Emitter.Ping on the package-level emitter.
+func Ping(ctx context.Context) error {
+	return loadDefault().Ping(ctx)
+}
+
+// Ping checks that the sidecar is ready by requesting its health endpoint.
+// It returns nil only if the sidecar responds with 200.
+func (e *Emitter) Ping(ctx context.Context) error {
+	path := e.HealthPath
+	if path == "" {
+		path = DefaultHealthPath
+	}
+	target, err := e.endpoint(path)
+	if err != nil {
+		return err
+	}
+	reqCtx, cancel := context.WithTimeout(ctx, e.timeout(nil))
+	defer cancel()
+	req, err := e.newRequest(reqCtx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
 	}
+	_, err = e.do(ctx, req, nil, "")
+	return err
 }
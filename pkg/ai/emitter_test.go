@@ -0,0 +1,95 @@
+package ai_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// headerServer starts a sidecar that records the headers of the last request.
+func headerServer(t *testing.T) (*httptest.Server, func() http.Header) {
+	t.Helper()
+	headers := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-headers:
+		default:
+		}
+		headers <- r.Header.Clone()
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() http.Header {
+		select {
+		case h := <-headers:
+			return h
+		default:
+			t.Fatal("no request received")
+			return nil
+		}
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	srv, lastHeaders := headerServer(t)
+	for _, token := range []string{"secret", ""} {
+		e := &ai.Emitter{URL: srv.URL + "/message", AuthToken: token}
+		if err := e.Emit(testPayload("msg1")); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+		auth, ok := lastHeaders()["Authorization"]
+		if token == "" && ok {
+			t.Errorf("Authorization header %q sent without a token", auth)
+		} else if token != "" && (len(auth) != 1 || auth[0] != "Bearer "+token) {
+			t.Errorf("Authorization header is %q, want %q", auth, "Bearer "+token)
+		}
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	srv, lastHeaders := headerServer(t)
+	e := &ai.Emitter{URL: srv.URL + "/message", Headers: http.Header{}}
+	e.Headers.Set("X-Bridge", "whatsapp")
+	e.Headers.Add("X-Tag", "a")
+	e.Headers.Add("X-Tag", "b")
+	if err := e.Emit(testPayload("msg1")); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	h := lastHeaders()
+	if h.Get("X-Bridge") != "whatsapp" || len(h.Values("X-Tag")) != 2 {
+		t.Errorf("custom headers not sent: %v", h)
+	} else if ct := h.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type is %q, want application/json", ct)
+	}
+
+	e = &ai.Emitter{URL: srv.URL + "/message", Headers: http.Header{}}
+	e.Headers.Set("Content-Type", "application/vnd.sidecar+json")
+	if err := e.Emit(testPayload("msg2")); err != nil {
+		t.Fatalf("Emit: %v", err)
+	} else if ct := lastHeaders().Get("Content-Type"); ct != "application/vnd.sidecar+json" {
+		t.Errorf("Content-Type is %q, want the one from Headers", ct)
+	}
+}
+
+func TestIdempotencyKey(t *testing.T) {
+	srv, lastHeaders := headerServer(t)
+	e := &ai.Emitter{URL: srv.URL + "/message"}
+	key := func(text string) string {
+		payload := testPayload("m1")
+		payload.Text = text
+		if err := e.Emit(payload); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+		return lastHeaders().Get(ai.IdempotencyKeyHeader)
+	}
+	first, repeat, changed := key("hello"), key("hello"), key("hello, world")
+	if !strings.HasPrefix(first, "whatsapp:m1:") {
+		t.Errorf("key %q doesn't start with the platform and message ID", first)
+	} else if repeat != first {
+		t.Errorf("re-emitting the same message changed the key from %q to %q", first, repeat)
+	} else if changed == first {
+		t.Errorf("changed content reused the key %q", first)
+	}
+}
@@ -0,0 +1,84 @@
+package ai
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+)
+
+// EndpointStrategy decides which of Emitter.Endpoints a request goes to.
+type EndpointStrategy int
+
+const (
+	// EndpointFailover sends every request to the first endpoint, and to the
+	// following ones only if the ones before it fail.
+	EndpointFailover EndpointStrategy = iota
+	// EndpointRoundRobin rotates the first endpoint tried between requests.
+	// Failed requests still move on to the next endpoint.
+	EndpointRoundRobin
+)
+
+// roundRobin picks the starting endpoint for EndpointRoundRobin. It is shared
+// by all emitters; it only needs to spread requests, not to be fair.
+var roundRobin atomic.Uint64
+
+// targets returns the URLs of the named endpoint on every sidecar that
+// handles platform, in the order they should be tried in.
+func (e *Emitter) targets(platform, name string) ([]string, error) {
+	bases := e.Endpoints
+	if route, ok := e.route(platform); ok {
+		bases = []string{route}
+	} else if len(bases) == 0 {
+		bases = []string{e.URL}
+	}
+	start := 0
+	if e.Strategy == EndpointRoundRobin && len(bases) > 1 {
+		start = int(roundRobin.Add(1) % uint64(len(bases)))
+	}
+	targets := make([]string, len(bases))
+	for i := range bases {
+		base := bases[(start+i)%len(bases)]
+		if name == "" {
+			targets[i] = base
+			continue
+		}
+		target, err := resolve(base, name)
+		if err != nil {
+			return nil, err
+		}
+		targets[i] = target
+	}
+	return targets, nil
+}
+
+// route returns the PlatformRoutes entry of the canonical platform. Keys
+// that are aliases or differ in case are normalized as well.
+func (e *Emitter) route(platform string) (string, bool) {
+	if route, ok := e.PlatformRoutes[platform]; ok {
+		return route, true
+	}
+	for key, route := range e.PlatformRoutes {
+		if e.normalizePlatform(key) == platform {
+			return route, true
+		}
+	}
+	return "", false
+}
+
+// postAny posts to each target in turn until one of them succeeds or fails
+// with an error that another sidecar would not fix.
+func (e *Emitter) postAny(ctx context.Context, em emission, targets []string, data []byte, gzipped bool) error {
+	var err error
+	for i, target := range targets {
+		err = e.post(ctx, em, target, data, gzipped)
+		if err == nil || !isRetryable(err) || ctx.Err() != nil {
+			return err
+		} else if i < len(targets)-1 {
+			e.logger().Debug("Sidecar endpoint failed, trying next one",
+				slog.String("url", target),
+				slog.Any("error", err),
+			)
+		}
+	}
+	return err
+}
@@ -0,0 +1,66 @@
+package ai_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestEndpointFailover(t *testing.T) {
+	down := httptest.NewServer(http.NotFoundHandler())
+	downURL := down.URL + "/message"
+	down.Close()
+	secondary := aitest.NewRecorder()
+	defer secondary.Close()
+
+	e := &ai.Emitter{
+		Endpoints: []string{downURL, secondary.MessageURL()},
+		Strategy:  ai.EndpointFailover,
+	}
+	for _, id := range []string{"msg1", "msg2"} {
+		if err := e.Emit(testPayload(id)); err != nil {
+			t.Fatalf("Emit %s: %v", id, err)
+		}
+	}
+	if n := len(secondary.Received()); n != 2 {
+		t.Fatalf("secondary received %d messages, want 2", n)
+	}
+}
+
+func TestPlatformRouteAliases(t *testing.T) {
+	main, telegram := aitest.NewRecorder(), aitest.NewRecorder()
+	defer main.Close()
+	defer telegram.Close()
+
+	for _, key := range []string{"Telegram", "tg"} {
+		telegram.Reset()
+		e := main.Emitter()
+		e.PlatformRoutes = map[string]string{key: telegram.MessageURL()}
+		payload := testPayload("msg1")
+		payload.Platform = "TG"
+		if err := e.Emit(payload); err != nil {
+			t.Fatalf("Emit: %v", err)
+		} else if n := telegram.Requests(); n != 1 {
+			t.Errorf("route keyed %q got %d requests, want 1", key, n)
+		}
+	}
+
+	if err := ai.Configure(main.MessageURL()); err != nil {
+		t.Fatal(err)
+	}
+	defer ai.SetDefault(nil)
+	telegram.Reset()
+	if err := ai.SetPlatformRoute("tg", telegram.MessageURL()); err != nil {
+		t.Fatal(err)
+	}
+	payload := testPayload("msg2")
+	payload.Platform = ai.PlatformTelegram
+	if err := ai.Emit(payload); err != nil {
+		t.Fatalf("Emit: %v", err)
+	} else if n := telegram.Requests(); n != 1 {
+		t.Errorf("SetPlatformRoute with an alias got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,146 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+func EmitReaction(payload ReactionPayload) error {
+	return loadDefault().EmitReaction(payload)
+}
+
+func EmitReactionContext(ctx context.Context, payload ReactionPayload) error {
+	return loadDefault().EmitReactionContext(ctx, payload)
+}
+
+func (e *Emitter) EmitReaction(payload ReactionPayload) error {
+	return e.EmitReactionContext(context.Background(), payload)
+}
+
+// EmitReactionContext posts payload to the "reaction" endpoint using the
+// same client and retry settings as EmitContext.
+func (e *Emitter) EmitReactionContext(ctx context.Context, payload ReactionPayload) error {
+	payload.Platform = e.normalizePlatform(payload.Platform)
+	if e.filtered(payload.Platform, payload.Sender) {
+		return nil
+	}
+	payload.SchemaVersion, payload.EventType = SchemaVersion, EventReaction
+	payload.Time = e.formatTime(payload.Timestamp)
+	return e.send(ctx, emission{
+		platform: payload.Platform,
+		endpoint: "reaction",
+		body:     payload,
+	})
+}
+
+func EmitEdit(payload MessagePayload) error {
+	return loadDefault().EmitEdit(payload)
+}
+
+func EmitEditContext(ctx context.Context, payload MessagePayload) error {
+	return loadDefault().EmitEditContext(ctx, payload)
+}
+
+func (e *Emitter) EmitEdit(payload MessagePayload) error {
+	return e.EmitEditContext(context.Background(), payload)
+}
+
+// EmitEditContext posts the new content of an edited message to the "edit"
+// endpoint. payload.MessageID must be the ID of the original message.
+// EditedAt defaults to the current time.
+func (e *Emitter) EmitEditContext(ctx context.Context, payload MessagePayload) error {
+	payload.Platform = e.normalizePlatform(payload.Platform)
+	if err := payload.Validate(); err != nil {
+		return err
+	} else if e.filtered(payload.Platform, payload.Sender) {
+		return nil
+	} else if payload.EditedAt == 0 {
+		payload.EditedAt = time.Now().UnixMilli()
+	}
+	e.prepare(&payload, EventEdit)
+	if ok, err := e.intercept(&payload); !ok {
+		return err
+	}
+	err := e.sendEdit(ctx, payload)
+	if err != nil {
+		e.deadLetter(ctx, payload, err)
+	}
+	return err
+}
+
+// sendEdit posts a prepared edit to the edit endpoint.
+func (e *Emitter) sendEdit(ctx context.Context, payload MessagePayload) error {
+	return e.send(ctx, emission{
+		platform:       payload.Platform,
+		endpoint:       "edit",
+		idempotencyKey: fmt.Sprintf("%s:%s:edit:%d", payload.Platform, payload.MessageID, payload.EditedAt),
+		body:           payload,
+	})
+}
+
+func EmitDelete(platform, roomID, messageID string) error {
+	return loadDefault().EmitDelete(platform, roomID, messageID)
+}
+
+func EmitDeleteContext(ctx context.Context, platform, roomID, messageID string) error {
+	return loadDefault().EmitDeleteContext(ctx, platform, roomID, messageID)
+}
+
+func (e *Emitter) EmitDelete(platform, roomID, messageID string) error {
+	return e.EmitDeleteContext(context.Background(), platform, roomID, messageID)
+}
+
+// EmitDeleteContext tells the sidecar that a message was deleted.
+func (e *Emitter) EmitDeleteContext(ctx context.Context, platform, roomID, messageID string) error {
+	platform = e.normalizePlatform(platform)
+	return e.send(ctx, emission{
+		platform:       platform,
+		endpoint:       "delete",
+		idempotencyKey: platform + ":" + messageID + ":delete",
+		body: DeletePayload{
+			SchemaVersion: SchemaVersion,
+			EventType:     EventDelete,
+			Platform:      platform,
+			RoomID:        roomID,
+			MessageID:     messageID,
+		},
+	})
+}
+
+func EmitReadReceipt(platform, roomID, sender, lastReadMessageID string, timestamp int64) error {
+	return loadDefault().EmitReadReceipt(platform, roomID, sender, lastReadMessageID, timestamp)
+}
+
+func EmitReadReceiptContext(ctx context.Context, platform, roomID, sender, lastReadMessageID string, timestamp int64) error {
+	return loadDefault().EmitReadReceiptContext(ctx, platform, roomID, sender, lastReadMessageID, timestamp)
+}
+
+func (e *Emitter) EmitReadReceipt(platform, roomID, sender, lastReadMessageID string, timestamp int64) error {
+	return e.EmitReadReceiptContext(context.Background(), platform, roomID, sender, lastReadMessageID, timestamp)
+}
+
+// EmitReadReceiptContext tells the sidecar that sender has read roomID up to
+// lastReadMessageID at timestamp, in Unix milliseconds. Like typing
+// notifications, receipts are sent once without retries.
+func (e *Emitter) EmitReadReceiptContext(ctx context.Context, platform, roomID, sender, lastReadMessageID string, timestamp int64) error {
+	platform = e.normalizePlatform(platform)
+	if e.filtered(platform, sender) {
+		return nil
+	}
+	return e.send(ctx, emission{
+		platform:   platform,
+		endpoint:   "receipt",
+		bestEffort: true,
+		body: ReceiptPayload{
+			SchemaVersion:     SchemaVersion,
+			EventType:         EventReceipt,
+			Platform:          platform,
+			RoomID:            roomID,
+			Sender:            sender,
+			LastReadMessageID: lastReadMessageID,
+			Timestamp:         timestamp,
+			Time:              e.formatTime(timestamp),
+		},
+	})
+}
@@ -0,0 +1,20 @@
+package ai
+
+import "slices"
+
+// FilterMetrics can be implemented by a Metrics to count payloads dropped
+// because of Emitter.SelfID or Emitter.IgnoreSenders.
+type FilterMetrics interface {
+	EmitFiltered(platform string)
+}
+
+// filtered reports whether payloads from sender should be dropped.
+func (e *Emitter) filtered(platform, sender string) bool {
+	if sender == "" || (sender != e.SelfID && !slices.Contains(e.IgnoreSenders, sender)) {
+		return false
+	}
+	if m, ok := e.Metrics.(FilterMetrics); ok {
+		m.EmitFiltered(platform)
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package ai
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+)
+
+// IdempotencyKeyHeader carries a key that is identical on every retry of an
+// emission. Message payloads use "<platform>:<message_id>:<content_hash>", so
+// re-emitting the same message also reuses the key, while a message whose
+// text or attachments changed gets a new one. Edits use
+// "<platform>:<message_id>:edit:<edited_at>" and deletions
+// "<platform>:<message_id>:delete"; other events get a random key per
+// emission. Sidecars should remember recently seen keys and answer repeats
+// with 200 without processing them again.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// messageKey returns the idempotency key of a message payload.
+func messageKey(payload *MessagePayload) string {
+	return payload.Platform + ":" + payload.MessageID + ":" + strconv.FormatUint(contentHash(payload), 16)
+}
+
+func randomKey() string {
+	var buf [16]byte
+	_, _ = rand.Read(buf[:])
+	return hex.EncodeToString(buf[:])
+}
@@ -0,0 +1,27 @@
+package ai
+
+import "errors"
+
+// ErrDropped can be returned by an Interceptor to drop a message on purpose.
+// The emit call then returns nil without contacting the sidecar.
+var ErrDropped = errors.New("ai: message dropped by interceptor")
+
+// Interceptor inspects or rewrites a message just before it is encoded.
+// Returning an error aborts the emission: ErrDropped (or an error wrapping
+// it) drops the message silently, any other error is returned to the caller.
+type Interceptor func(MessagePayload) (MessagePayload, error)
+
+// intercept runs e.Interceptors on payload in order. It reports false if the
+// message must not be sent, along with the error to return, if any.
+func (e *Emitter) intercept(payload *MessagePayload) (bool, error) {
+	for _, interceptor := range e.Interceptors {
+		next, err := interceptor(*payload)
+		if errors.Is(err, ErrDropped) {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+		*payload = next
+	}
+	return true, nil
+}
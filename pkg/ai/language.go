@@ -0,0 +1,15 @@
+package ai
+
+// LanguageDetector guesses the language of message text. DetectLanguage
+// returns a BCP 47 tag such as "en" or "pt-BR", or an empty string if it
+// can't tell. It runs on the emitting goroutine, so it should be cheap.
+type LanguageDetector interface {
+	DetectLanguage(text string) string
+}
+
+// LanguageDetectorFunc adapts a function to the LanguageDetector interface.
+type LanguageDetectorFunc func(text string) string
+
+func (f LanguageDetectorFunc) DetectLanguage(text string) string {
+	return f(text)
+}
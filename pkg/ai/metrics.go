@@ -0,0 +1,58 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Metrics is notified about every emission. The aiprom subpackage provides
+// a Prometheus implementation; this package itself has no dependency on it.
+type Metrics interface {
+	// EmitDone is called once per emission, after any retries, with the
+	// final error or nil.
+	EmitDone(platform string, err error)
+	// RequestDone is called after every HTTP request to the sidecar.
+	RequestDone(platform string, duration time.Duration)
+}
+
+// Failure reasons returned by FailureReason.
+const (
+	FailureMarshal     = "marshal"
+	FailureTooLarge    = "too_large"
+	FailureTransport   = "transport"
+	FailureStatus      = "status"
+	FailureCanceled    = "canceled"
+	FailureRateLimited = "rate_limited"
+	FailureCircuitOpen = "circuit_open"
+	FailureNotAcked    = "not_acked"
+	FailureOther       = "other"
+)
+
+// FailureReason classifies an error returned by the emitter for use as a
+// metric label. It returns an empty string for a nil error.
+func FailureReason(err error) string {
+	var statusErr *StatusError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrMarshal):
+		return FailureMarshal
+	case errors.Is(err, ErrPayloadTooLarge):
+		return FailureTooLarge
+	case errors.As(err, &statusErr):
+		return FailureStatus
+	case errors.Is(err, ErrSidecarUnavailable):
+		return FailureTransport
+	case errors.Is(err, ErrNotAcknowledged):
+		return FailureNotAcked
+	case errors.Is(err, ErrCircuitOpen):
+		return FailureCircuitOpen
+	case errors.Is(err, ErrRateLimited):
+		return FailureRateLimited
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return FailureCanceled
+	default:
+		return FailureOther
+	}
+}
@@ -0,0 +1,125 @@
+package ai
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Option configures an Emitter built by New.
+type Option func(e *Emitter) error
+
+// New returns an Emitter configured by opts, applied in order. Without
+// WithURL it uses DefaultSidecarURL. As with any Emitter, its settings are
+// fixed once it is returned, so it can be shared by goroutines freely.
+func New(opts ...Option) (*Emitter, error) {
+	e := &Emitter{URL: DefaultSidecarURL}
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
+	}
+	// The fallback file logs like the emitter, whichever option came first.
+	if sink, ok := e.DeadLetters.(*FileDeadLetterSink); ok && sink.Logger == nil {
+		sink.Logger = e.Logger
+	}
+	return e, nil
+}
+
+// WithURL sets the sidecar URL. See Configure for the accepted formats.
+func WithURL(rawURL string) Option {
+	return func(e *Emitter) error {
+		return e.setURL(rawURL)
+	}
+}
+
+// WithTimeout sets Emitter.Timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(e *Emitter) error {
+		e.Timeout = timeout
+		return nil
+	}
+}
+
+// WithRetries sets Emitter.MaxRetries, and Emitter.BaseBackoff if backoff is
+// positive.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(e *Emitter) error {
+		e.MaxRetries = maxRetries
+		if backoff > 0 {
+			e.BaseBackoff = backoff
+		}
+		return nil
+	}
+}
+
+// WithAuthToken sets Emitter.AuthToken.
+func WithAuthToken(token string) Option {
+	return func(e *Emitter) error {
+		e.AuthToken = token
+		return nil
+	}
+}
+
+// WithLogger sets Emitter.Logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(e *Emitter) error {
+		e.Logger = logger
+		return nil
+	}
+}
+
+// WithHTTPClient sets Emitter.Client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(e *Emitter) error {
+		e.Client = client
+		return nil
+	}
+}
+
+// WithPoolOptions sets Emitter.Client to a client created by NewPooledClient.
+// It replaces any client set with WithHTTPClient or WithTLSConfig.
+func WithPoolOptions(opts PoolOptions) Option {
+	return func(e *Emitter) error {
+		e.Client = NewPooledClient(opts)
+		return nil
+	}
+}
+
+// WithTLSConfig sets Emitter.Client to a client created by NewTLSClient. It
+// replaces any client set with WithHTTPClient or WithPoolOptions.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(e *Emitter) error {
+		e.Client = NewTLSClient(cfg)
+		return nil
+	}
+}
+
+// WithCodec sets Emitter.Codec.
+func WithCodec(codec Codec) Option {
+	return func(e *Emitter) error {
+		e.Codec = codec
+		return nil
+	}
+}
+
+// WithFallbackFile stores messages that could not be delivered, after
+// retries, as JSON lines in the file at path, rotated once it exceeds
+// maxBytes. See NewRotatingFileDeadLetterSink. It is a lightweight
+// alternative to a spool: the file is only a record to inspect or Replay,
+// nothing is resent automatically. The file logs through Emitter.Logger. An
+// empty path leaves Emitter.DeadLetters unchanged.
+func WithFallbackFile(path string, maxBytes int64) Option {
+	return func(e *Emitter) error {
+		if path == "" {
+			return nil
+		}
+		sink, err := NewRotatingFileDeadLetterSink(path, maxBytes)
+		if err != nil {
+			return err
+		}
+		e.DeadLetters = sink
+		return nil
+	}
+}
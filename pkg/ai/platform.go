@@ -0,0 +1,63 @@
+package ai
+
+import "strings"
+
+// Canonical platform names. Emitters rewrite the aliases listed in
+// DefaultPlatformAliases to these.
+const (
+	PlatformWhatsApp  = "whatsapp"
+	PlatformTelegram  = "telegram"
+	PlatformSignal    = "signal"
+	PlatformDiscord   = "discord"
+	PlatformSlack     = "slack"
+	PlatformInstagram = "instagram"
+	PlatformMessenger = "messenger"
+	PlatformIMessage  = "imessage"
+	PlatformSMS       = "sms"
+)
+
+// DefaultPlatformAliases maps lowercase platform names and their common
+// abbreviations to the canonical names. It is used when
+// Emitter.PlatformAliases is nil.
+var DefaultPlatformAliases = map[string]string{
+	PlatformWhatsApp:  PlatformWhatsApp,
+	"wa":              PlatformWhatsApp,
+	PlatformTelegram:  PlatformTelegram,
+	"tg":              PlatformTelegram,
+	PlatformSignal:    PlatformSignal,
+	PlatformDiscord:   PlatformDiscord,
+	PlatformSlack:     PlatformSlack,
+	PlatformInstagram: PlatformInstagram,
+	"ig":              PlatformInstagram,
+	PlatformMessenger: PlatformMessenger,
+	"facebook":        PlatformMessenger,
+	"fb":              PlatformMessenger,
+	PlatformIMessage:  PlatformIMessage,
+	PlatformSMS:       PlatformSMS,
+	"gmessages":       PlatformSMS,
+}
+
+// NormalizePlatform returns the canonical name of platform according to
+// DefaultPlatformAliases, which are looked up in lowercase. Unknown platforms
+// are returned unchanged. Emitters do this themselves; it is meant for code
+// that handles payloads before they reach one.
+func NormalizePlatform(platform string) string {
+	return normalizePlatform(DefaultPlatformAliases, platform)
+}
+
+// normalizePlatform is like NormalizePlatform, but uses e.PlatformAliases if
+// they are set.
+func (e *Emitter) normalizePlatform(platform string) string {
+	aliases := e.PlatformAliases
+	if aliases == nil {
+		aliases = DefaultPlatformAliases
+	}
+	return normalizePlatform(aliases, platform)
+}
+
+func normalizePlatform(aliases map[string]string, platform string) string {
+	if canonical, ok := aliases[strings.ToLower(strings.TrimSpace(platform))]; ok {
+		return canonical
+	}
+	return platform
+}
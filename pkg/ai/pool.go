@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"net/http"
+	"time"
+)
+
+// PoolOptions tune connection reuse of the HTTP transport. Zero fields keep
+// the values of the shared default client, which allows 32 idle connections
+// to the sidecar.
+type PoolOptions struct {
+	// MaxIdleConns limits idle connections across all hosts.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost limits idle connections kept open to each sidecar.
+	// Requests beyond it still succeed, but their connections are closed
+	// afterwards instead of being reused.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost, if positive, limits all connections to each sidecar;
+	// further requests wait for a free one.
+	MaxConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept open.
+	IdleConnTimeout time.Duration
+}
+
+// NewPooledClient returns a client like the shared default one, with its
+// connection pool tuned by opts. Assign it to Emitter.Client.
+func NewPooledClient(opts PoolOptions) *http.Client {
+	transport := newDefaultTransport()
+	if opts.MaxIdleConns > 0 {
+		transport.MaxIdleConns = opts.MaxIdleConns
+	}
+	if opts.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		if transport.MaxIdleConns > 0 && transport.MaxIdleConns < opts.MaxIdleConnsPerHost {
+			transport.MaxIdleConns = opts.MaxIdleConnsPerHost
+		}
+	}
+	if opts.MaxConnsPerHost > 0 {
+		transport.MaxConnsPerHost = opts.MaxConnsPerHost
+	}
+	if opts.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+	}
+	return &http.Client{Transport: transport}
+}
+
+// SetPoolOptions makes the package-level emitter use a client created by
+// NewPooledClient. It replaces any client set with SetHTTPClient or
+// SetTLSConfig. The limits also apply to a unix:// sidecar's socket.
+func SetPoolOptions(opts PoolOptions) {
+	updateDefault(func(e *Emitter) error {
+		e.Client = NewPooledClient(opts)
+		return nil
+	})
+}
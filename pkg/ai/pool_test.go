@@ -0,0 +1,85 @@
+package ai_test
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// connCountingServer starts a sidecar that counts the connections opened to it.
+func connCountingServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+	return srv, &conns
+}
+
+func TestPoolReusesConnections(t *testing.T) {
+	srv, conns := connCountingServer(t)
+	e, err := ai.New(ai.WithURL(srv.URL+"/message"), ai.WithPoolOptions(ai.PoolOptions{MaxIdleConnsPerHost: 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 20 {
+		if err = e.Emit(testPayload(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("sequential emits opened %d connections, want 1", n)
+	}
+}
+
+func TestPoolMaxConnsPerHost(t *testing.T) {
+	srv, conns := connCountingServer(t)
+	const maxConns = 2
+	e, err := ai.New(ai.WithURL(srv.URL+"/message"), ai.WithPoolOptions(ai.PoolOptions{MaxConnsPerHost: maxConns}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := e.Emit(testPayload(fmt.Sprintf("msg%d", i))); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := conns.Load(); n > maxConns {
+		t.Fatalf("concurrent emits opened %d connections, want at most %d", n, maxConns)
+	}
+}
+
+func BenchmarkPooledEmit(b *testing.B) {
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	defer srv.Close()
+	e, err := ai.New(ai.WithURL(srv.URL+"/message"), ai.WithPoolOptions(ai.PoolOptions{}))
+	if err != nil {
+		b.Fatal(err)
+	}
+	payload := testPayload("msg1")
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := e.Emit(payload); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
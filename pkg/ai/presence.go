@@ -0,0 +1,63 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// DefaultPresenceInterval is used when Emitter.PresenceInterval is zero.
+const DefaultPresenceInterval = 30 * time.Second
+
+// Presence statuses accepted by EmitPresence.
+const (
+	PresenceOnline  = "online"
+	PresenceOffline = "offline"
+	PresenceAway    = "away"
+)
+
+func EmitPresence(platform, userID, status string, lastActive int64) error {
+	return loadDefault().EmitPresence(platform, userID, status, lastActive)
+}
+
+func EmitPresenceContext(ctx context.Context, platform, userID, status string, lastActive int64) error {
+	return loadDefault().EmitPresenceContext(ctx, platform, userID, status, lastActive)
+}
+
+func (e *Emitter) EmitPresence(platform, userID, status string, lastActive int64) error {
+	return e.EmitPresenceContext(context.Background(), platform, userID, status, lastActive)
+}
+
+// EmitPresenceContext tells the sidecar that userID is now online, offline
+// or away. lastActive is in Unix milliseconds and may be zero if unknown.
+// A status other than PresenceOnline, PresenceOffline or PresenceAway is
+// rejected with ErrInvalidPayload.
+//
+// Like typing notifications, presence updates are sent once without retries,
+// and repeats of the status last sent for a user within PresenceInterval are
+// dropped without error.
+func (e *Emitter) EmitPresenceContext(ctx context.Context, platform, userID, status string, lastActive int64) error {
+	switch status {
+	case PresenceOnline, PresenceOffline, PresenceAway:
+	default:
+		return fmt.Errorf("%w: unknown presence status %q", ErrInvalidPayload, status)
+	}
+	platform = e.normalizePlatform(platform)
+	key := "presence\x00" + platform + "\x00" + userID
+	if e.filtered(platform, userID) || !e.throttle(key, status, e.PresenceInterval, DefaultPresenceInterval) {
+		return nil
+	}
+	return e.send(ctx, emission{
+		platform:   platform,
+		endpoint:   "presence",
+		bestEffort: true,
+		body: PresencePayload{
+			SchemaVersion: SchemaVersion,
+			EventType:     EventPresence,
+			Platform:      platform,
+			UserID:        userID,
+			Status:        status,
+			LastActive:    lastActive,
+		},
+	})
+}
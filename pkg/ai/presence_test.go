@@ -0,0 +1,27 @@
+package ai_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestPresenceStatus(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	e := rec.Emitter()
+
+	for _, status := range []string{ai.PresenceOnline, ai.PresenceOffline, ai.PresenceAway} {
+		if err := e.EmitPresence(ai.PlatformWhatsApp, "@alice", status, 0); err != nil {
+			t.Errorf("EmitPresence %s: %v", status, err)
+		}
+	}
+	if err := e.EmitPresence(ai.PlatformWhatsApp, "@alice", "busy", 0); !errors.Is(err, ai.ErrInvalidPayload) {
+		t.Errorf("EmitPresence busy returned %v, want ErrInvalidPayload", err)
+	}
+	if n := rec.Requests(); n != 3 {
+		t.Fatalf("got %d requests, want 3", n)
+	}
+}
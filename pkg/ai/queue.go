@@ -0,0 +1,326 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultQueueSize is used by NewQueueEmitter when size is not positive.
+const DefaultQueueSize = 256
+
+var (
+	// ErrQueueFull is returned by Enqueue when the buffer has no free slot.
+	ErrQueueFull = errors.New("ai: emit queue is full")
+	// ErrClosed is returned by Enqueue after the queue has been closed.
+	ErrClosed = errors.New("ai: emit queue is closed")
+)
+
+// QueueEmitter emits payloads from background goroutines so that callers
+// never wait for the sidecar.
+//
+// Enqueue never blocks: when the buffer is full, the new payload is rejected
+// with ErrQueueFull and the payloads already queued are kept.
+// EnqueueWithTimeout waits for a free slot instead, up to a deadline.
+//
+// A queue from NewQueueEmitter has a single worker, so payloads are emitted
+// in the order they were enqueued. NewShardedQueueEmitter starts several
+// workers, each with its own buffer, and assigns every room to one of them:
+// payloads of the same room are still emitted in order, while different
+// rooms are emitted concurrently.
+type QueueEmitter struct {
+	emitter MessageEmitter
+	queues  []chan MessagePayload
+	done    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+
+	// Logger receives delivery failures. It must be set before the first
+	// Enqueue. Defaults to slog.Default().
+	Logger *slog.Logger
+	// OnResult, if set, is called with every payload taken off the queue and
+	// the error from emitting it (nil on success). Payloads dropped by an
+	// aborted Shutdown are reported with the context error. It runs on the
+	// worker goroutine, so it must return quickly; a slow callback holds up
+	// the worker's payloads. With several workers it is called concurrently.
+	// Like Logger, it must be set before the first Enqueue.
+	OnResult func(payload MessagePayload, err error)
+
+	// sendLock is held for reading while sending to queues, and for writing
+	// while closing them. closing is closed by Shutdown before it takes the
+	// write lock, so that waiting senders give up and new ones never queue
+	// up behind the pending writer.
+	sendLock    sync.RWMutex
+	closed      bool
+	closing     chan struct{}
+	closingOnce sync.Once
+
+	lock    sync.Mutex
+	pending int
+	idle    []chan struct{}
+
+	enqueued atomic.Uint64
+	sent     atomic.Uint64
+	dropped  atomic.Uint64
+	failed   atomic.Uint64
+	blocked  atomic.Uint64
+}
+
+// QueueStats is a snapshot of a queue's state and lifetime counters.
+type QueueStats struct {
+	// Depth is the number of payloads waiting in the buffers, and Capacity
+	// the total size of the buffers.
+	Depth    int
+	Capacity int
+	// Enqueued counts accepted payloads, Sent and Failed the outcomes of
+	// emitting them. Dropped counts payloads rejected because the buffer
+	// was full or discarded by an aborted Shutdown.
+	Enqueued uint64
+	Sent     uint64
+	Dropped  uint64
+	Failed   uint64
+	// Blocked counts calls to Enqueue or EnqueueWithTimeout that found the
+	// buffer full, whether they then waited for a slot or were rejected.
+	// A growing count means the sidecar is not keeping up.
+	Blocked uint64
+}
+
+// NewQueueEmitter starts a queue with room for size payloads that drains
+// into emitter.
+func NewQueueEmitter(emitter MessageEmitter, size int) *QueueEmitter {
+	return NewShardedQueueEmitter(emitter, size, 1)
+}
+
+// NewShardedQueueEmitter starts a queue with the given number of workers,
+// each with room for size payloads, that drains into emitter. Payloads are
+// assigned to workers by RoomID. The number of workers is deliberately fixed
+// when the queue is created rather than being a setting: changing it would
+// move rooms to other workers and break the order of their payloads.
+func NewShardedQueueEmitter(emitter MessageEmitter, size, workers int) *QueueEmitter {
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	workers = max(workers, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &QueueEmitter{
+		emitter: emitter,
+		queues:  make([]chan MessagePayload, workers),
+		done:    make(chan struct{}),
+		closing: make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := range q.queues {
+		queue := make(chan MessagePayload, size)
+		q.queues[i] = queue
+		go func() {
+			defer wg.Done()
+			q.run(queue)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(q.done)
+	}()
+	return q
+}
+
+// shard returns the buffer of the worker that handles roomID.
+func (q *QueueEmitter) shard(roomID string) chan MessagePayload {
+	if len(q.queues) == 1 {
+		return q.queues[0]
+	}
+	h := fnv.New32a()
+	h.Write([]byte(roomID))
+	return q.queues[h.Sum32()%uint32(len(q.queues))]
+}
+
+// Enqueue adds payload to the queue and returns immediately.
+func (q *QueueEmitter) Enqueue(payload MessagePayload) error {
+	return q.enqueue(payload, 0)
+}
+
+// EnqueueWithTimeout is like Enqueue, but if the buffer is full it waits up to
+// timeout for a slot to free up before returning ErrQueueFull.
+func (q *QueueEmitter) EnqueueWithTimeout(payload MessagePayload, timeout time.Duration) error {
+	return q.enqueue(payload, timeout)
+}
+
+func (q *QueueEmitter) enqueue(payload MessagePayload, timeout time.Duration) error {
+	select {
+	case <-q.closing:
+		return ErrClosed
+	default:
+	}
+	q.sendLock.RLock()
+	defer q.sendLock.RUnlock()
+	if q.closed {
+		return ErrClosed
+	}
+	// Count the payload as pending before sending it, so that the worker
+	// never finishes it first.
+	q.lock.Lock()
+	q.pending++
+	q.lock.Unlock()
+	queue := q.shard(payload.RoomID)
+	select {
+	case queue <- payload:
+		q.enqueued.Add(1)
+		return nil
+	default:
+		q.blocked.Add(1)
+	}
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		select {
+		case queue <- payload:
+			q.enqueued.Add(1)
+			return nil
+		case <-timer.C:
+		case <-q.closing:
+			q.finish()
+			return ErrClosed
+		}
+	}
+	q.dropped.Add(1)
+	q.finish()
+	return ErrQueueFull
+}
+
+func (q *QueueEmitter) run(queue chan MessagePayload) {
+	for payload := range queue {
+		// After an aborted Shutdown the rest of the queue is dropped.
+		err := q.ctx.Err()
+		if err != nil {
+			q.dropped.Add(1)
+		} else if err = q.emitter.EmitContext(q.ctx, payload); err == nil {
+			q.sent.Add(1)
+		} else {
+			q.failed.Add(1)
+			q.logger().Warn("Failed to emit queued message",
+				slog.String("platform", payload.Platform),
+				slog.String("room_id", payload.RoomID),
+				slog.String("message_id", payload.MessageID),
+				slog.Any("error", err),
+			)
+		}
+		if q.OnResult != nil {
+			q.OnResult(payload, err)
+		}
+		q.finish()
+	}
+}
+
+// Stats returns the current state of the queue. It is cheap and safe to call
+// at any time, but the fields are read one by one and may be slightly out of
+// step with each other.
+func (q *QueueEmitter) Stats() QueueStats {
+	var depth, capacity int
+	for _, queue := range q.queues {
+		depth += len(queue)
+		capacity += cap(queue)
+	}
+	return QueueStats{
+		Depth:    depth,
+		Capacity: capacity,
+		Enqueued: q.enqueued.Load(),
+		Sent:     q.sent.Load(),
+		Dropped:  q.dropped.Load(),
+		Failed:   q.failed.Load(),
+		Blocked:  q.blocked.Load(),
+	}
+}
+
+func (q *QueueEmitter) logger() *slog.Logger {
+	if q.Logger != nil {
+		return q.Logger
+	}
+	return slog.Default()
+}
+
+func (q *QueueEmitter) finish() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.pending--
+	if q.pending == 0 {
+		for _, ch := range q.idle {
+			close(ch)
+		}
+		q.idle = nil
+	}
+}
+
+// Flush waits until every payload enqueued so far has been emitted.
+func (q *QueueEmitter) Flush(ctx context.Context) error {
+	q.lock.Lock()
+	if q.pending == 0 {
+		q.lock.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	q.idle = append(q.idle, ch)
+	q.lock.Unlock()
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownError is returned by Shutdown when ctx ends before the queue is
+// drained.
+type ShutdownError struct {
+	Pending int
+	Err     error
+}
+
+func (e *ShutdownError) Error() string {
+	return fmt.Sprintf("ai: queue shut down with %d payloads pending: %v", e.Pending, e.Err)
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+// Shutdown stops accepting payloads and waits for the queue to drain. If ctx
+// ends first, the payloads still pending are dropped and a *ShutdownError
+// reporting how many there were is returned. Enqueue returns ErrClosed after
+// Shutdown has been called, as do calls to EnqueueWithTimeout that are still
+// waiting for a slot.
+func (q *QueueEmitter) Shutdown(ctx context.Context) error {
+	q.closingOnce.Do(func() {
+		close(q.closing)
+	})
+	q.sendLock.Lock()
+	if !q.closed {
+		q.closed = true
+		for _, queue := range q.queues {
+			close(queue)
+		}
+	}
+	q.sendLock.Unlock()
+	select {
+	case <-q.done:
+		return nil
+	case <-ctx.Done():
+		q.lock.Lock()
+		pending := q.pending
+		q.lock.Unlock()
+		q.cancel()
+		return &ShutdownError{Pending: pending, Err: ctx.Err()}
+	}
+}
+
+// Close is Shutdown without a deadline.
+func (q *QueueEmitter) Close() error {
+	return q.Shutdown(context.Background())
+}
@@ -0,0 +1,206 @@
+package ai_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// stuckEmitter blocks every emit until release is closed.
+type stuckEmitter struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func newStuckEmitter() *stuckEmitter {
+	return &stuckEmitter{started: make(chan struct{}, 16), release: make(chan struct{})}
+}
+
+func (s *stuckEmitter) EmitContext(ctx context.Context, _ ai.MessagePayload) error {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func testPayload(id string) ai.MessagePayload {
+	return ai.NewMessagePayload(ai.PlatformWhatsApp, "!room", "@alice", id, time.Now())
+}
+
+// fillQueue leaves the worker of a queue of size 1 stuck on one payload
+// with another one in the buffer.
+func fillQueue(t *testing.T, q *ai.QueueEmitter, emitter *stuckEmitter) {
+	t.Helper()
+	if err := q.Enqueue(testPayload("running")); err != nil {
+		t.Fatal(err)
+	}
+	<-emitter.started
+	if err := q.Enqueue(testPayload("buffered")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestQueueShutdownReleasesWaiters(t *testing.T) {
+	emitter := newStuckEmitter()
+	q := ai.NewQueueEmitter(emitter, 1)
+	fillQueue(t, q, emitter)
+
+	waiter := make(chan error, 1)
+	go func() {
+		waiter <- q.EnqueueWithTimeout(testPayload("waiting"), 5*time.Second)
+	}()
+	// Give the waiter time to block on the full buffer.
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- q.Close()
+	}()
+	select {
+	case err := <-waiter:
+		if !errors.Is(err, ai.ErrClosed) {
+			t.Fatalf("waiting EnqueueWithTimeout returned %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("EnqueueWithTimeout kept waiting after Shutdown")
+	}
+
+	start := time.Now()
+	if err := q.Enqueue(testPayload("late")); !errors.Is(err, ai.ErrClosed) {
+		t.Fatalf("Enqueue after Shutdown returned %v, want ErrClosed", err)
+	} else if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("Enqueue blocked for %s during Shutdown", elapsed)
+	}
+
+	close(emitter.release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+// failingEmitter fails every payload with the message ID "bad".
+type failingEmitter struct {
+	*stuckEmitter
+}
+
+func (f failingEmitter) EmitContext(ctx context.Context, payload ai.MessagePayload) error {
+	if payload.MessageID == "bad" {
+		return errors.New("rejected")
+	}
+	return f.stuckEmitter.EmitContext(ctx, payload)
+}
+
+func TestQueueStats(t *testing.T) {
+	emitter := newStuckEmitter()
+	q := ai.NewQueueEmitter(failingEmitter{emitter}, 1)
+	q.Logger = ai.DiscardLogger
+	fillQueue(t, q, emitter)
+	if err := q.Enqueue(testPayload("bad")); !errors.Is(err, ai.ErrQueueFull) {
+		t.Fatalf("Enqueue on a full queue returned %v, want ErrQueueFull", err)
+	}
+	want := ai.QueueStats{Depth: 1, Capacity: 1, Enqueued: 2, Dropped: 1, Blocked: 1}
+	if stats := q.Stats(); stats != want {
+		t.Fatalf("full queue stats are %+v, want %+v", stats, want)
+	}
+
+	close(emitter.release)
+	if err := q.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if err = q.Enqueue(testPayload("bad")); err != nil {
+		t.Fatal(err)
+	} else if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want = ai.QueueStats{Capacity: 1, Enqueued: 3, Sent: 2, Failed: 1, Dropped: 1, Blocked: 1}
+	if stats := q.Stats(); stats != want {
+		t.Fatalf("drained queue stats are %+v, want %+v", stats, want)
+	}
+}
+
+func TestQueueEnqueueWithTimeout(t *testing.T) {
+	emitter := newStuckEmitter()
+	q := ai.NewQueueEmitter(emitter, 1)
+	fillQueue(t, q, emitter)
+
+	const timeout = 50 * time.Millisecond
+	start := time.Now()
+	err := q.EnqueueWithTimeout(testPayload("waiting"), timeout)
+	if elapsed := time.Since(start); !errors.Is(err, ai.ErrQueueFull) {
+		t.Fatalf("EnqueueWithTimeout returned %v, want ErrQueueFull", err)
+	} else if elapsed < timeout {
+		t.Fatalf("EnqueueWithTimeout gave up after %s, before the %s timeout", elapsed, timeout)
+	}
+	if stats := q.Stats(); stats.Blocked != 1 || stats.Dropped != 1 || stats.Enqueued != 2 {
+		t.Fatalf("stats are %+v, want 1 blocked, 1 dropped and 2 enqueued", stats)
+	}
+
+	close(emitter.release)
+	if err = q.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// orderEmitter records the message IDs emitted for each room.
+type orderEmitter struct {
+	lock  sync.Mutex
+	rooms map[string][]int
+}
+
+func (o *orderEmitter) EmitContext(_ context.Context, payload ai.MessagePayload) error {
+	seq, err := strconv.Atoi(payload.MessageID)
+	if err != nil {
+		return err
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.rooms[payload.RoomID] = append(o.rooms[payload.RoomID], seq)
+	return nil
+}
+
+func TestShardedQueueRoomOrder(t *testing.T) {
+	const rooms, perRoom, workers = 32, 200, 8
+	emitter := &orderEmitter{rooms: make(map[string][]int)}
+	q := ai.NewShardedQueueEmitter(emitter, 16, workers)
+
+	var wg sync.WaitGroup
+	for r := range rooms {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range perRoom {
+				payload := testPayload(strconv.Itoa(i))
+				payload.RoomID = fmt.Sprintf("!room%d", r)
+				if err := q.EnqueueWithTimeout(payload, 5*time.Second); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(emitter.rooms) != rooms {
+		t.Fatalf("got payloads for %d rooms, want %d", len(emitter.rooms), rooms)
+	}
+	for room, seqs := range emitter.rooms {
+		if len(seqs) != perRoom {
+			t.Fatalf("%s got %d payloads, want %d", room, len(seqs), perRoom)
+		}
+		for i, seq := range seqs {
+			if seq != i {
+				t.Fatalf("%s got payload %d in position %d", room, seq, i)
+			}
+		}
+	}
+}
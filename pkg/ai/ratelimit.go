@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a RateLimiter with RateLimitReject has no
+// tokens left.
+var ErrRateLimited = errors.New("ai: rate limit exceeded")
+
+// RateLimitPolicy decides what happens to an emission over the limit.
+type RateLimitPolicy int
+
+const (
+	// RateLimitBlock waits until a token is available or the context ends.
+	RateLimitBlock RateLimitPolicy = iota
+	// RateLimitReject fails immediately with ErrRateLimited.
+	RateLimitReject
+)
+
+// RateLimiter is a token bucket allowing perSecond emissions on average with
+// bursts of up to burst emissions. It is safe for concurrent use and can be
+// shared between emitters.
+type RateLimiter struct {
+	perSecond float64
+	burst     float64
+	policy    RateLimitPolicy
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter returns a limiter whose bucket starts full.
+func NewRateLimiter(perSecond float64, burst int, policy RateLimitPolicy) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		policy:    policy,
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// Wait takes a token, blocking or failing according to the policy.
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.lock.Lock()
+	now := time.Now()
+	l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.perSecond)
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		l.lock.Unlock()
+		return nil
+	} else if l.policy == RateLimitReject || l.perSecond <= 0 {
+		l.lock.Unlock()
+		return ErrRateLimited
+	}
+	// Reserve the token now so concurrent waiters queue up behind each other.
+	wait := time.Duration((1 - l.tokens) / l.perSecond * float64(time.Second))
+	l.tokens--
+	l.lock.Unlock()
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.lock.Lock()
+		l.tokens++
+		l.lock.Unlock()
+		return ctx.Err()
+	}
+}
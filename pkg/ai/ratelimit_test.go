@@ -0,0 +1,59 @@
+package ai_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestRateLimitBlock(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	const rate, over = 50, 10
+	e := rec.Emitter()
+	e.RateLimiter = ai.NewRateLimiter(rate, 1, ai.RateLimitBlock)
+
+	start := time.Now()
+	for i := range over + 1 {
+		if err := e.Emit(testPayload(fmt.Sprintf("msg%d", i))); err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+	elapsed := time.Since(start)
+	want := over * time.Second / rate
+	if elapsed < want*8/10 || elapsed > want*3 {
+		t.Fatalf("%d emits over the limit took %s, want about %s", over, elapsed, want)
+	} else if n := rec.Requests(); n != over+1 {
+		t.Fatalf("got %d requests, want %d", n, over+1)
+	}
+}
+
+func TestRateLimitReject(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	const burst, over = 2, 3
+	e := rec.Emitter()
+	e.RateLimiter = ai.NewRateLimiter(0.1, burst, ai.RateLimitReject)
+
+	start := time.Now()
+	var rejected int
+	for i := range burst + over {
+		err := e.Emit(testPayload(fmt.Sprintf("msg%d", i)))
+		if errors.Is(err, ai.ErrRateLimited) {
+			rejected++
+		} else if err != nil {
+			t.Fatalf("Emit: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("rejecting emits took %s", elapsed)
+	} else if rejected != over {
+		t.Fatalf("%d emits were rejected, want %d", rejected, over)
+	} else if n := rec.Requests(); n != burst {
+		t.Fatalf("got %d requests, want %d", n, burst)
+	}
+}
@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"regexp"
+	"slices"
+)
+
+// Redactor scrubs sensitive content from message text before it leaves the
+// bridge. It is applied to MessagePayload.Text, FormattedText, QuotedText,
+// attachment captions and poll questions and options.
+type Redactor interface {
+	Redact(text string) string
+}
+
+// DefaultRedactReplacement replaces matches of a RegexRedactor whose
+// Replacement is empty.
+const DefaultRedactReplacement = "[redacted]"
+
+// DefaultRedactPatterns match email addresses, payment card numbers and
+// phone numbers, either international (+ prefix) or in the 123-456-7890 form.
+var DefaultRedactPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+	regexp.MustCompile(`\+\d(?:[ ().-]?\d){6,14}`),
+	regexp.MustCompile(`\(?\b\d{3}\)?[ .-]?\d{3}[ .-]?\d{4}\b`),
+}
+
+// RegexRedactor replaces every match of Patterns with Replacement.
+type RegexRedactor struct {
+	Patterns    []*regexp.Regexp
+	Replacement string
+}
+
+// NewRegexRedactor returns a RegexRedactor using DefaultRedactPatterns.
+func NewRegexRedactor() *RegexRedactor {
+	return &RegexRedactor{Patterns: DefaultRedactPatterns}
+}
+
+func (r *RegexRedactor) Redact(text string) string {
+	replacement := r.Replacement
+	if replacement == "" {
+		replacement = DefaultRedactReplacement
+	}
+	for _, pattern := range r.Patterns {
+		text = pattern.ReplaceAllLiteralString(text, replacement)
+	}
+	return text
+}
+
+// redact applies e.Redactor to the text fields of payload. Attachments and
+// polls are copied first so the caller's values are left alone.
+func (e *Emitter) redact(payload *MessagePayload) {
+	if e.Redactor == nil {
+		return
+	}
+	payload.Text = e.Redactor.Redact(payload.Text)
+	payload.FormattedText = e.Redactor.Redact(payload.FormattedText)
+	payload.QuotedText = e.Redactor.Redact(payload.QuotedText)
+	payload.Poll = mapPoll(payload.Poll, e.Redactor.Redact)
+	if len(payload.Attachments) == 0 {
+		return
+	}
+	payload.Attachments = slices.Clone(payload.Attachments)
+	for i := range payload.Attachments {
+		payload.Attachments[i].Caption = e.Redactor.Redact(payload.Attachments[i].Caption)
+	}
+}
+
+// mapPoll returns a copy of poll with fn applied to the question and options.
+func mapPoll(poll *PollData, fn func(string) string) *PollData {
+	if poll == nil {
+		return nil
+	}
+	mapped := *poll
+	mapped.Question = fn(poll.Question)
+	mapped.Options = make([]string, len(poll.Options))
+	for i, option := range poll.Options {
+		mapped.Options[i] = fn(option)
+	}
+	return &mapped
+}
@@ -0,0 +1,67 @@
+package ai_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+const secret = "bob@example.com"
+
+// emitRedacted emits payload through an emitter with the default redactor and
+// returns what the sidecar received.
+func emitRedacted(t *testing.T, payload ai.MessagePayload) ai.MessagePayload {
+	t.Helper()
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	e := rec.Emitter()
+	e.Redactor = ai.NewRegexRedactor()
+	if err := e.Emit(payload); err != nil {
+		t.Fatalf("Emit: %v", err)
+	}
+	received := rec.Received()
+	if len(received) != 1 {
+		t.Fatalf("got %d messages, want 1", len(received))
+	}
+	return received[0]
+}
+
+func TestRedactMessage(t *testing.T) {
+	payload := testPayload("msg1")
+	payload.Text = "mail " + secret
+	payload.FormattedText = "<b>mail " + secret + "</b>"
+	payload.QuotedText = "mail " + secret
+	payload.Attachments = []ai.Attachment{{Type: ai.AttachmentImage, Caption: "mail " + secret}}
+
+	got := emitRedacted(t, payload)
+	for name, text := range map[string]string{
+		"text":           got.Text,
+		"formatted text": got.FormattedText,
+		"quoted text":    got.QuotedText,
+		"caption":        got.Attachments[0].Caption,
+	} {
+		if strings.Contains(text, secret) || !strings.Contains(text, ai.DefaultRedactReplacement) {
+			t.Errorf("%s wasn't redacted: %q", name, text)
+		}
+	}
+	if payload.Attachments[0].Caption != "mail "+secret {
+		t.Error("the caller's attachments were modified")
+	}
+}
+
+func TestRedactPoll(t *testing.T) {
+	const phone = "+15551234567"
+	payload := testPayload("msg1")
+	payload.Poll = &ai.PollData{Question: "call " + phone, Options: []string{"yes", "mail " + secret}}
+
+	got := emitRedacted(t, payload).Poll
+	if strings.Contains(got.Question, phone) {
+		t.Errorf("poll question wasn't redacted: %q", got.Question)
+	} else if got.Options[0] != "yes" || strings.Contains(got.Options[1], secret) {
+		t.Errorf("poll options weren't redacted: %q", got.Options)
+	} else if payload.Poll.Question != "call "+phone {
+		t.Error("the caller's poll was modified")
+	}
+}
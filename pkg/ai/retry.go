@@ -0,0 +1,59 @@
+package ai
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DefaultBaseBackoff is used when Emitter.BaseBackoff is zero.
+const DefaultBaseBackoff = 100 * time.Millisecond
+
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrSidecarUnavailable) || errors.Is(err, ErrNotAcknowledged) {
+		return true
+	}
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode >= 500
+}
+
+// retry calls fn until it succeeds, returns a non-retryable error, or
+// MaxRetries is exhausted. Backoff waits are cut short by ctx.
+func (e *Emitter) retry(ctx context.Context, fn func() error) error {
+	backoff := e.BaseBackoff
+	if backoff <= 0 {
+		backoff = DefaultBaseBackoff
+	}
+	attempts := 0
+	for {
+		attempts++
+		err := fn()
+		if err == nil || e.MaxRetries <= 0 {
+			return err
+		} else if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		} else if !isRetryable(err) {
+			return fmt.Errorf("ai: failed after %d attempts: %w", attempts, err)
+		} else if attempts > e.MaxRetries {
+			return fmt.Errorf("ai: giving up after %d attempts: %w", attempts, err)
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return fmt.Errorf("ai: giving up after %d attempts, deadline too close to retry: %w", attempts, err)
+		}
+		e.logger().Debug("Retrying sidecar request",
+			slog.Int("attempt", attempts),
+			slog.Duration("backoff", backoff),
+			slog.Any("error", err),
+		)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,43 @@
+package ai_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+func TestRetryServerErrors(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	e := rec.Emitter()
+	e.MaxRetries, e.BaseBackoff = 3, time.Millisecond
+
+	rec.FailNext(2, aitest.Fault{StatusCode: http.StatusServiceUnavailable})
+	if err := e.Emit(testPayload("msg1")); err != nil {
+		t.Fatalf("Emit: %v", err)
+	} else if n := rec.Requests(); n != 3 {
+		t.Fatalf("got %d requests, want 3", n)
+	} else if len(rec.Received()) != 1 {
+		t.Fatal("message wasn't delivered")
+	}
+}
+
+func TestRetryClientErrors(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	e := rec.Emitter()
+	e.MaxRetries, e.BaseBackoff = 3, time.Millisecond
+
+	rec.FailNext(1, aitest.Fault{StatusCode: http.StatusBadRequest})
+	err := e.Emit(testPayload("msg1"))
+	var statusErr *ai.StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Emit returned %v, want a 400 StatusError", err)
+	} else if n := rec.Requests(); n != 1 {
+		t.Fatalf("got %d requests, want 1", n)
+	}
+}
@@ -0,0 +1,40 @@
+package ai
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers set on signed requests.
+//
+// The signature is an HMAC-SHA256 keyed with Emitter.SigningKey over the
+// decimal Unix timestamp from SignatureTimestampHeader, a '.', and the body
+// exactly as sent (after compression, if any). It is sent as
+// "sha256=<hex>". Sidecars should reject stale timestamps to limit replays.
+const (
+	SignatureHeader          = "X-Nexa-Signature"
+	SignatureTimestampHeader = "X-Nexa-Timestamp"
+)
+
+// SetSigningKey enables request signing on the package-level emitter.
+func SetSigningKey(key []byte) {
+	updateDefault(func(e *Emitter) error {
+		e.SigningKey = key
+		return nil
+	})
+}
+
+// sign adds the timestamp and signature headers for body to req.
+func (e *Emitter) sign(req *http.Request, body []byte) {
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, e.SigningKey)
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	req.Header.Set(SignatureTimestampHeader, ts)
+	req.Header.Set(SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+}
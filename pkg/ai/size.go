@@ -0,0 +1,95 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultMaxPayloadBytes is used when Emitter.MaxPayloadBytes is zero.
+const DefaultMaxPayloadBytes = 1 << 20
+
+// TruncationMarker is appended to Text when OversizeTruncate shortens it.
+const TruncationMarker = "… [truncated]"
+
+// ErrPayloadTooLarge is returned when an encoded body exceeds
+// Emitter.MaxPayloadBytes and can't be truncated to fit.
+var ErrPayloadTooLarge = errors.New("ai: payload too large")
+
+// OversizePolicy decides what happens to a body over MaxPayloadBytes.
+type OversizePolicy int
+
+const (
+	// OversizeReject fails the emission with ErrPayloadTooLarge.
+	OversizeReject OversizePolicy = iota
+	// OversizeTruncate drops the FormattedText of a single message, then
+	// shortens its Text and appends TruncationMarker until the body fits.
+	// Other bodies are rejected.
+	OversizeTruncate
+)
+
+// fit checks data, the encoding of em.body, against the size limit and
+// returns the body to send.
+func (e *Emitter) fit(em *emission, data []byte) ([]byte, error) {
+	limit := e.MaxPayloadBytes
+	if limit == 0 {
+		limit = DefaultMaxPayloadBytes
+	}
+	if limit < 0 || len(data) <= limit {
+		return data, nil
+	}
+	payload, ok := em.body.(MessagePayload)
+	if e.OversizePolicy != OversizeTruncate || !ok {
+		return nil, fmt.Errorf("%w: %d bytes, limit is %d", ErrPayloadTooLarge, len(data), limit)
+	}
+	if payload.FormattedText != "" {
+		// Text has the same content, so drop the formatted copy first.
+		payload.FormattedText, payload.Format = "", ""
+		var err error
+		if data, err = e.marshal(em, payload); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMarshal, err)
+		} else if len(data) <= limit {
+			em.body = payload
+			return data, nil
+		}
+	}
+	// Escaping makes the encoded size of text hard to predict, so search for
+	// the longest prefix that fits.
+	text := payload.Text
+	encode := func(keep int) ([]byte, error) {
+		payload.Text = ""
+		if keep > 0 {
+			payload.Text = text[:keep] + TruncationMarker
+		}
+		data, err := e.marshal(em, payload)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMarshal, err)
+		}
+		return data, nil
+	}
+	if data, err := encode(0); err != nil {
+		return nil, err
+	} else if len(data) > limit {
+		return nil, fmt.Errorf("%w: %d bytes without text, limit is %d", ErrPayloadTooLarge, len(data), limit)
+	}
+	lo, hi := 0, len(text)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if data, err := encode(mid); err != nil {
+			return nil, err
+		} else if len(data) <= limit {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	for lo > 0 && !utf8.RuneStart(text[lo]) {
+		lo--
+	}
+	data, err := encode(lo)
+	if err != nil {
+		return nil, err
+	}
+	em.body = payload
+	return data, nil
+}
@@ -0,0 +1,238 @@
+package ai
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// spoolRecord is one line of a spool file. A record with a payload adds a
+// message; one without acknowledges the message with the same ID.
+type spoolRecord struct {
+	ID      uint64          `json:"id"`
+	Payload *MessagePayload `json:"payload,omitempty"`
+}
+
+// SpooledEmitter is a QueueEmitter backed by a write-ahead spool file, so
+// that messages queued but not yet delivered survive a restart.
+//
+// Every message is appended to the spool before it is queued and marked as
+// done once it has been delivered. Messages that fail to be delivered stay in
+// the spool and are queued again when the spool is next opened, so delivery
+// is at-least-once.
+type SpooledEmitter struct {
+	queue  *QueueEmitter
+	logger *slog.Logger
+
+	lock     sync.Mutex
+	file     *os.File
+	closed   bool
+	nextID   uint64
+	inFlight []uint64
+	unacked  int
+}
+
+// SpoolOptions configure a SpooledEmitter.
+type SpoolOptions struct {
+	// Size is the capacity of the queue, DefaultQueueSize if not positive.
+	// It grows to fit the messages left in the spool.
+	Size int
+	// Logger receives spool problems and, as QueueEmitter.Logger, delivery
+	// failures. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// NewSpooledEmitter opens or creates the spool at path, queues the messages
+// left in it for delivery, and then accepts new ones like NewQueueEmitter.
+// Corrupt records, such as a line cut short by a crash, are skipped.
+func NewSpooledEmitter(path string, emitter MessageEmitter, opts SpoolOptions) (*SpooledEmitter, error) {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	pending, err := readSpool(path, logger)
+	if err != nil {
+		return nil, err
+	}
+	// Rewrite the spool with only the pending messages, so it doesn't grow
+	// across restarts.
+	tmp := path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_APPEND|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("ai: create spool: %w", err)
+	}
+	s := &SpooledEmitter{logger: logger, file: file}
+	for i := range pending {
+		if err = s.write(spoolRecord{ID: s.nextID, Payload: &pending[i]}); err != nil {
+			file.Close()
+			return nil, err
+		}
+		s.inFlight = append(s.inFlight, s.nextID)
+		s.nextID++
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ai: write spool: %w", err)
+	} else if err = os.Rename(tmp, path); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ai: replace spool: %w", err)
+	}
+	s.unacked = len(pending)
+
+	size := opts.Size
+	if size <= 0 {
+		size = DefaultQueueSize
+	}
+	s.queue = NewQueueEmitter(emitter, max(size, len(pending)))
+	s.queue.Logger = logger
+	s.queue.OnResult = s.result
+	for _, payload := range pending {
+		if err = s.queue.Enqueue(payload); err != nil {
+			s.abort()
+			return nil, fmt.Errorf("ai: requeue spooled messages: %w", err)
+		}
+	}
+	return s, nil
+}
+
+func readSpool(path string, logger *slog.Logger) ([]MessagePayload, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("ai: open spool: %w", err)
+	}
+	defer file.Close()
+
+	var order []uint64
+	added := make(map[uint64]MessagePayload)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec spoolRecord
+		if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			logger.Warn("Skipping corrupt spool record",
+				slog.String("path", path),
+				slog.Int("line", line),
+				slog.Any("error", err),
+			)
+		} else if rec.Payload != nil {
+			order = append(order, rec.ID)
+			added[rec.ID] = *rec.Payload
+		} else {
+			delete(added, rec.ID)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("ai: read spool: %w", err)
+	}
+	var pending []MessagePayload
+	for _, id := range order {
+		if payload, ok := added[id]; ok {
+			pending = append(pending, payload)
+		}
+	}
+	return pending, nil
+}
+
+func (s *SpooledEmitter) write(rec spoolRecord) error {
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMarshal, err)
+	}
+	if _, err = s.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("ai: write spool: %w", err)
+	}
+	return nil
+}
+
+// Enqueue writes payload to the spool and queues it.
+func (s *SpooledEmitter) Enqueue(payload MessagePayload) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	id := s.nextID
+	if err := s.write(spoolRecord{ID: id, Payload: &payload}); err != nil {
+		return err
+	} else if err = s.file.Sync(); err != nil {
+		return fmt.Errorf("ai: write spool: %w", err)
+	}
+	s.nextID++
+	if err := s.queue.Enqueue(payload); err != nil {
+		// An unacknowledged record would be sent again on the next start.
+		if ackErr := s.write(spoolRecord{ID: id}); ackErr != nil {
+			s.unacked++
+		}
+		return err
+	}
+	s.inFlight = append(s.inFlight, id)
+	s.unacked++
+	return nil
+}
+
+// result is the queue's OnResult. The queue has a single worker, so results
+// arrive in the order the messages were queued.
+func (s *SpooledEmitter) result(_ MessagePayload, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := s.inFlight[0]
+	s.inFlight = s.inFlight[1:]
+	if err != nil || s.closed {
+		return
+	}
+	if err = s.write(spoolRecord{ID: id}); err != nil {
+		s.logger.Warn("Failed to mark spooled message as delivered", slog.Any("error", err))
+		return
+	}
+	s.unacked--
+	if s.unacked == 0 {
+		// Nothing is left to replay, so start the file over.
+		if err = s.file.Truncate(0); err != nil {
+			s.logger.Warn("Failed to truncate spool", slog.Any("error", err))
+		}
+	}
+}
+
+// Flush waits until every payload enqueued so far has been processed.
+func (s *SpooledEmitter) Flush(ctx context.Context) error {
+	return s.queue.Flush(ctx)
+}
+
+// Shutdown stops the queue like QueueEmitter.Shutdown and closes the spool.
+// Payloads still pending stay in the spool for the next start.
+func (s *SpooledEmitter) Shutdown(ctx context.Context) error {
+	err := s.queue.Shutdown(ctx)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return err
+	}
+	s.closed = true
+	if closeErr := s.file.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("ai: close spool: %w", closeErr)
+	}
+	return err
+}
+
+// abort drops the queued messages without marking them as delivered, so they
+// are all sent again on the next start, and closes the spool.
+func (s *SpooledEmitter) abort() {
+	s.lock.Lock()
+	s.closed = true
+	s.lock.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	s.queue.Shutdown(ctx)
+	s.file.Close()
+}
+
+// Close is Shutdown without a deadline.
+func (s *SpooledEmitter) Close() error {
+	return s.Shutdown(context.Background())
+}
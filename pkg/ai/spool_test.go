@@ -0,0 +1,97 @@
+package ai_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"github.com/mautrix/whatsapp/pkg/ai/aitest"
+)
+
+// openSpool opens the spool at path, delivers what is left in it and returns
+// the IDs of the messages the recorder received.
+func openSpool(t *testing.T, path string, rec *aitest.Recorder, opts ai.SpoolOptions) (*ai.SpooledEmitter, []string) {
+	t.Helper()
+	rec.Reset()
+	s, err := ai.NewSpooledEmitter(path, rec.Emitter(), opts)
+	if err != nil {
+		t.Fatal(err)
+	} else if err = s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, payload := range rec.Received() {
+		ids = append(ids, payload.MessageID)
+	}
+	return s, ids
+}
+
+func TestSpoolReplayAfterRestart(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+
+	s, _ := openSpool(t, path, rec, ai.SpoolOptions{Logger: ai.DiscardLogger})
+	rec.FailNext(2, aitest.Fault{StatusCode: http.StatusBadRequest})
+	for _, id := range []string{"failed1", "failed2", "delivered"} {
+		if err := s.Enqueue(testPayload(id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, ids := openSpool(t, path, rec, ai.SpoolOptions{Logger: ai.DiscardLogger})
+	if !slices.Equal(ids, []string{"failed1", "failed2"}) {
+		t.Fatalf("replayed %v after a restart, want the failed messages in order", ids)
+	} else if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, ids = openSpool(t, path, rec, ai.SpoolOptions{Logger: ai.DiscardLogger})
+	defer s.Close()
+	if len(ids) != 0 {
+		t.Fatalf("replayed %v again after they were delivered", ids)
+	}
+}
+
+func TestSpoolTruncatedRecord(t *testing.T) {
+	rec := aitest.NewRecorder()
+	defer rec.Close()
+	path := filepath.Join(t.TempDir(), "spool.jsonl")
+
+	s, _ := openSpool(t, path, rec, ai.SpoolOptions{Logger: ai.DiscardLogger})
+	rec.FailNext(1, aitest.Fault{StatusCode: http.StatusBadRequest})
+	if err := s.Enqueue(testPayload("pending")); err != nil {
+		t.Fatal(err)
+	} else if err = s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	} else if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Simulate a crash halfway through appending the next record.
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"id":1,"payload":{"platform":"whats`)
+	file.Close()
+
+	var logs bytes.Buffer
+	s, ids := openSpool(t, path, rec, ai.SpoolOptions{Logger: slog.New(slog.NewTextHandler(&logs, nil))})
+	defer s.Close()
+	if !slices.Equal(ids, []string{"pending"}) {
+		t.Fatalf("replayed %v, want the message before the truncated record", ids)
+	} else if !strings.Contains(logs.String(), "Skipping corrupt spool record") {
+		t.Fatalf("truncated record wasn't logged through SpoolOptions.Logger: %s", logs.String())
+	}
+}
@@ -0,0 +1,44 @@
+package ai
+
+import (
+	"sync"
+	"time"
+)
+
+// throttleMapLimit is the number of keys above which expired states are
+// pruned.
+const throttleMapLimit = 1024
+
+// throttleLock guards the recent field of every Emitter.
+var throttleLock sync.Mutex
+
+type recentState struct {
+	value string
+	until time.Time
+}
+
+// throttle reports whether value should be sent for key. It returns false if
+// the same value was sent for key less than interval (or def, if interval is
+// not positive) ago.
+func (e *Emitter) throttle(key, value string, interval, def time.Duration) bool {
+	if interval <= 0 {
+		interval = def
+	}
+	now := time.Now()
+	throttleLock.Lock()
+	defer throttleLock.Unlock()
+	if last, ok := e.recent[key]; ok && last.value == value && now.Before(last.until) {
+		return false
+	}
+	if e.recent == nil {
+		e.recent = make(map[string]recentState)
+	} else if len(e.recent) >= throttleMapLimit {
+		for k, state := range e.recent {
+			if !now.Before(state.until) {
+				delete(e.recent, k)
+			}
+		}
+	}
+	e.recent[key] = recentState{value: value, until: now.Add(interval)}
+	return true
+}
@@ -0,0 +1,75 @@
+package ai
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// TLSOptions describes the TLS setup for talking to an https:// sidecar.
+// It has no effect on http:// or unix:// URLs.
+type TLSOptions struct {
+	// CAFile is a PEM file with the certificates used to verify the
+	// sidecar, replacing the system roots.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key for mutual
+	// TLS. Both or neither must be set.
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the name used to verify the sidecar certificate.
+	ServerName string
+	// InsecureSkipVerify disables verification of the sidecar certificate.
+	// It is never enabled implicitly; only use it for local testing.
+	InsecureSkipVerify bool
+}
+
+// LoadTLSConfig builds a tls.Config from opts.
+func LoadTLSConfig(opts TLSOptions) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         opts.ServerName,
+		InsecureSkipVerify: opts.InsecureSkipVerify,
+	}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("ai: read CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ai: no certificates found in %s", opts.CAFile)
+		}
+	}
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return nil, errors.New("ai: client certificate and key must be set together")
+	} else if opts.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("ai: load client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// NewTLSClient returns a client like the shared default one, but using cfg
+// for https:// connections. Assign it to Emitter.Client.
+func NewTLSClient(cfg *tls.Config) *http.Client {
+	transport := newDefaultTransport()
+	transport.TLSClientConfig = cfg
+	return &http.Client{Transport: transport}
+}
+
+// SetTLSConfig makes the package-level emitter use cfg for https://
+// connections. It replaces any client set with SetHTTPClient or
+// SetPoolOptions. A unix:// sidecar keeps being dialed over its socket,
+// without TLS.
+func SetTLSConfig(cfg *tls.Config) {
+	updateDefault(func(e *Emitter) error {
+		e.Client = NewTLSClient(cfg)
+		return nil
+	})
+}
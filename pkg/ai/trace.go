@@ -0,0 +1,53 @@
+package ai
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer starts a span for every emission. The aiotel subpackage provides an
+// OpenTelemetry implementation. Nothing is traced if Emitter.Tracer is nil.
+type Tracer interface {
+	StartEmit(ctx context.Context, info SpanInfo) (context.Context, Span)
+}
+
+// Span is a single traced emission, covering all of its attempts.
+type Span interface {
+	// Inject adds the span's trace context to the headers of a request.
+	Inject(header http.Header)
+	// RequestDone is called after every request with the response status
+	// code, or 0 if there was no response.
+	RequestDone(statusCode int)
+	// End finishes the span with the final error of the emission.
+	End(err error)
+}
+
+// SpanInfo describes the emission a span is started for. RoomID and
+// MessageID are empty when they don't apply, e.g. for batches.
+type SpanInfo struct {
+	// Endpoint is the name of the endpoint, such as "message" or "reaction".
+	Endpoint  string
+	Platform  string
+	RoomID    string
+	MessageID string
+}
+
+func (em *emission) spanInfo() SpanInfo {
+	info := SpanInfo{Endpoint: em.endpoint, Platform: em.platform}
+	if info.Endpoint == "" {
+		info.Endpoint = "message"
+	}
+	switch body := em.body.(type) {
+	case MessagePayload:
+		info.RoomID, info.MessageID = body.RoomID, body.MessageID
+	case ReactionPayload:
+		info.RoomID, info.MessageID = body.RoomID, body.TargetMessageID
+	case DeletePayload:
+		info.RoomID, info.MessageID = body.RoomID, body.MessageID
+	case ReceiptPayload:
+		info.RoomID, info.MessageID = body.RoomID, body.LastReadMessageID
+	case TypingPayload:
+		info.RoomID = body.RoomID
+	}
+	return info
+}
@@ -0,0 +1,65 @@
+package ai_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+// statusTracer records the status codes reported to its spans.
+type statusTracer struct {
+	lock     sync.Mutex
+	statuses []int
+}
+
+func (s *statusTracer) StartEmit(ctx context.Context, _ ai.SpanInfo) (context.Context, ai.Span) {
+	return ctx, s
+}
+
+func (s *statusTracer) Inject(http.Header) {}
+
+func (s *statusTracer) RequestDone(statusCode int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.statuses = append(s.statuses, statusCode)
+}
+
+func (s *statusTracer) End(error) {}
+
+func TestSpanStatusCode(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload ai.MessagePayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch payload.MessageID {
+		case "confirmed":
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(ai.Ack{MessageID: payload.MessageID})
+		case "rejected":
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer srv.Close()
+	tracer := &statusTracer{}
+	e := &ai.Emitter{URL: srv.URL + "/message", Tracer: tracer}
+
+	if err := e.Emit(testPayload("plain")); err != nil {
+		t.Fatalf("Emit: %v", err)
+	} else if err = e.EmitConfirmed(context.Background(), testPayload("confirmed")); err != nil {
+		t.Fatalf("EmitConfirmed: %v", err)
+	} else if err = e.Emit(testPayload("rejected")); err == nil {
+		t.Fatal("Emit succeeded despite the 422")
+	}
+	want := []int{http.StatusOK, http.StatusAccepted, http.StatusUnprocessableEntity}
+	if !slices.Equal(tracer.statuses, want) {
+		t.Fatalf("spans reported %v, want %v", tracer.statuses, want)
+	}
+}
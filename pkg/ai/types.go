@@ -0,0 +1,262 @@
+package ai
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidPayload is returned by Validate, and by Emit before any request
+// is made, when a required field is missing.
+var ErrInvalidPayload = errors.New("ai: invalid payload")
+
+// SchemaVersion is the version of the payload format. The emitter sends it
+// in the schema_version field of every payload, and it is bumped whenever
+// the wire format changes. Version 4 omits sender_name and is_group when
+// they are empty.
+const SchemaVersion = 4
+
+// EventType tells the sidecar what kind of event a payload describes, so it
+// can route on the event_type field instead of on the endpoint path.
+type EventType string
+
+const (
+	EventMessage  EventType = "message"
+	EventEdit     EventType = "edit"
+	EventReaction EventType = "reaction"
+	EventDelete   EventType = "delete"
+	EventTyping   EventType = "typing"
+	EventReceipt  EventType = "receipt"
+	EventPresence EventType = "presence"
+)
+
+type MessagePayload struct {
+	// SchemaVersion and EventType are set by the emitter. EventType is
+	// EventEdit for payloads sent through EmitEdit, EventMessage otherwise.
+	SchemaVersion int       `json:"schema_version"`
+	EventType     EventType `json:"event_type"`
+
+	Platform   string `json:"platform"`
+	RoomID     string `json:"room_id"`
+	Sender     string `json:"sender"`
+	SenderName string `json:"sender_name,omitempty"`
+	IsGroup    bool   `json:"is_group,omitempty"`
+	// Timestamp is the time the message was sent in Unix milliseconds.
+	Timestamp int64 `json:"timestamp"`
+	// Time is Timestamp as an RFC 3339 string. The emitter sets it if
+	// Emitter.RFC3339Time is enabled.
+	Time string `json:"time,omitempty"`
+	// Text is the plain text body. For media messages it carries the
+	// caption, if any.
+	Text      string `json:"text"`
+	MessageID string `json:"message_id"`
+	// FormattedText is the rich version of Text in the given Format, if the
+	// message has formatting.
+	FormattedText string     `json:"formatted_text,omitempty"`
+	Format        TextFormat `json:"format,omitempty"`
+	// Language is the BCP 47 tag of the language Text is written in, if the
+	// platform provides it or Emitter.LanguageDetector recognizes it.
+	Language string `json:"language,omitempty"`
+
+	Attachments []Attachment `json:"attachments,omitempty"`
+	// Poll and Location describe structured messages. Text then carries a
+	// human-readable fallback, such as the poll question.
+	Poll     *PollData     `json:"poll,omitempty"`
+	Location *LocationData `json:"location,omitempty"`
+
+	// ReplyToMessageID and ReplyToSender identify the message this one
+	// replies to, if any.
+	ReplyToMessageID string `json:"reply_to_message_id,omitempty"`
+	ReplyToSender    string `json:"reply_to_sender,omitempty"`
+	// QuotedText and QuotedSenderName inline the message being replied to,
+	// for sidecars that haven't seen it. QuotedText is shortened to
+	// Emitter.MaxQuotedText runes.
+	QuotedText       string `json:"quoted_text,omitempty"`
+	QuotedSenderName string `json:"quoted_sender_name,omitempty"`
+	// ThreadID is the message ID of the thread root. An empty ThreadID
+	// means the message is in the main timeline.
+	ThreadID string `json:"thread_id,omitempty"`
+
+	// EditedAt is set on payloads sent through EmitEdit, in Unix
+	// milliseconds. Timestamp keeps the time the original message was sent.
+	EditedAt int64 `json:"edited_at,omitempty"`
+
+	// SenderAvatarURL is the sender's avatar, as an mxc:// URI for Matrix
+	// bridges. SenderColor is a display color such as "#1e90ff". Both are
+	// omitted when the platform doesn't provide them.
+	SenderAvatarURL string `json:"sender_avatar_url,omitempty"`
+	SenderColor     string `json:"sender_color,omitempty"`
+
+	// RoomName and MemberCount describe group rooms. They are omitted for
+	// direct messages and when the bridge doesn't know the room state.
+	RoomName    string `json:"room_name,omitempty"`
+	MemberCount int    `json:"member_count,omitempty"`
+
+	// Mentions holds the platform user IDs mentioned in the message.
+	// MentionsMe is set if the bridge's own user is one of them.
+	Mentions   []string `json:"mentions,omitempty"`
+	MentionsMe bool     `json:"mentions_me,omitempty"`
+
+	// CorrelationID is an opaque token for this emission of the message,
+	// unlike MessageID, which is the platform's ID. The emitter generates a
+	// random one if it is empty. The sidecar should echo it in its Response
+	// so that the bridge can match the reply to the message that triggered
+	// it, even when the reply arrives later or over another channel.
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// Timestamps outside of 2000-2100 are most likely not in milliseconds.
+const (
+	minTimestamp = 946684800000
+	maxTimestamp = 4102444800000
+)
+
+// NewMessagePayload returns a payload with the fields required by Validate,
+// with Timestamp set from sentAt.
+func NewMessagePayload(platform, roomID, sender, messageID string, sentAt time.Time) MessagePayload {
+	return MessagePayload{
+		Platform:  platform,
+		RoomID:    roomID,
+		Sender:    sender,
+		MessageID: messageID,
+		Timestamp: sentAt.UnixMilli(),
+	}
+}
+
+// Validate checks that the fields the sidecar needs to identify a message are
+// set: Platform, RoomID, Sender, MessageID and a Timestamp that looks like
+// Unix milliseconds.
+func (p *MessagePayload) Validate() error {
+	switch {
+	case p.Platform == "":
+		return fmt.Errorf("%w: missing platform", ErrInvalidPayload)
+	case p.RoomID == "":
+		return fmt.Errorf("%w: missing room_id", ErrInvalidPayload)
+	case p.Sender == "":
+		return fmt.Errorf("%w: missing sender", ErrInvalidPayload)
+	case p.MessageID == "":
+		return fmt.Errorf("%w: missing message_id", ErrInvalidPayload)
+	case p.Timestamp == 0:
+		return fmt.Errorf("%w: missing timestamp", ErrInvalidPayload)
+	case p.Timestamp < minTimestamp || p.Timestamp > maxTimestamp:
+		return fmt.Errorf("%w: timestamp %d is not in Unix milliseconds", ErrInvalidPayload, p.Timestamp)
+	default:
+		return nil
+	}
+}
+
+// Response is the body returned by the sidecar to EmitAndReceive.
+type Response struct {
+	Reply       string   `json:"reply"`
+	ShouldReply bool     `json:"should_reply"`
+	Actions     []string `json:"actions,omitempty"`
+	// CorrelationID is the MessagePayload.CorrelationID of the message the
+	// response is for. EmitAndReceive fills it in if the sidecar left it out.
+	CorrelationID string `json:"correlation_id,omitempty"`
+}
+
+// PollData is the content of a poll message.
+type PollData struct {
+	Question string   `json:"question"`
+	Options  []string `json:"options"`
+	// Votes holds the number of votes for each of Options, if known.
+	Votes []int `json:"votes,omitempty"`
+}
+
+// LocationData is a shared location. Label is the place name or address, if
+// the sender included one.
+type LocationData struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Label     string  `json:"label,omitempty"`
+}
+
+// TextFormat is the markup used in MessagePayload.FormattedText.
+type TextFormat string
+
+const (
+	FormatHTML     TextFormat = "html"
+	FormatMarkdown TextFormat = "markdown"
+)
+
+type AttachmentType string
+
+const (
+	AttachmentImage AttachmentType = "image"
+	AttachmentVideo AttachmentType = "video"
+	AttachmentAudio AttachmentType = "audio"
+	AttachmentFile  AttachmentType = "file"
+)
+
+// Attachment describes a media file sent with a message. At least one of
+// URL or MXC is set.
+type Attachment struct {
+	Type     AttachmentType `json:"type"`
+	URL      string         `json:"url,omitempty"`
+	MXC      string         `json:"mxc,omitempty"`
+	MimeType string         `json:"mime_type,omitempty"`
+	Size     int64          `json:"size,omitempty"`
+	Caption  string         `json:"caption,omitempty"`
+}
+
+// ReactionPayload is sent to the "reaction" endpoint when a reaction is added
+// or, with Removed set, taken back.
+type ReactionPayload struct {
+	SchemaVersion   int       `json:"schema_version"`
+	EventType       EventType `json:"event_type"`
+	Platform        string    `json:"platform"`
+	RoomID          string    `json:"room_id"`
+	Sender          string    `json:"sender"`
+	TargetMessageID string    `json:"target_message_id"`
+	Emoji           string    `json:"emoji"`
+	Removed         bool      `json:"removed"`
+	// Timestamp is in Unix milliseconds, Time is set like in MessagePayload.
+	Timestamp int64  `json:"timestamp"`
+	Time      string `json:"time,omitempty"`
+}
+
+// DeletePayload is sent to the "delete" endpoint when a message is deleted.
+type DeletePayload struct {
+	SchemaVersion int       `json:"schema_version"`
+	EventType     EventType `json:"event_type"`
+	Platform      string    `json:"platform"`
+	RoomID        string    `json:"room_id"`
+	MessageID     string    `json:"message_id"`
+}
+
+// ReceiptPayload is sent to the "receipt" endpoint when a user has read a
+// room up to a message.
+type ReceiptPayload struct {
+	SchemaVersion     int       `json:"schema_version"`
+	EventType         EventType `json:"event_type"`
+	Platform          string    `json:"platform"`
+	RoomID            string    `json:"room_id"`
+	Sender            string    `json:"sender"`
+	LastReadMessageID string    `json:"last_read_message_id"`
+	// Timestamp is in Unix milliseconds, Time is set like in MessagePayload.
+	Timestamp int64  `json:"timestamp"`
+	Time      string `json:"time,omitempty"`
+}
+
+// TypingPayload is sent to the "typing" endpoint when a user starts or stops
+// typing. It is a hint for the sidecar and should not be persisted.
+type TypingPayload struct {
+	SchemaVersion int       `json:"schema_version"`
+	EventType     EventType `json:"event_type"`
+	Platform      string    `json:"platform"`
+	RoomID        string    `json:"room_id"`
+	Sender        string    `json:"sender"`
+	Typing        bool      `json:"typing"`
+}
+
+// PresencePayload is sent to the "presence" endpoint when a user's online
+// status changes. Like TypingPayload, it is only a hint.
+type PresencePayload struct {
+	SchemaVersion int       `json:"schema_version"`
+	EventType     EventType `json:"event_type"`
+	Platform      string    `json:"platform"`
+	UserID        string    `json:"user_id"`
+	Status        string    `json:"status"`
+	// LastActive is in Unix milliseconds, or zero if unknown.
+	LastActive int64 `json:"last_active,omitempty"`
+}
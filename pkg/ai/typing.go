@@ -0,0 +1,48 @@
+package ai
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// DefaultTypingInterval is used when Emitter.TypingInterval is zero.
+const DefaultTypingInterval = 3 * time.Second
+
+func EmitTyping(platform, roomID, sender string, typing bool) error {
+	return loadDefault().EmitTyping(platform, roomID, sender, typing)
+}
+
+func EmitTypingContext(ctx context.Context, platform, roomID, sender string, typing bool) error {
+	return loadDefault().EmitTypingContext(ctx, platform, roomID, sender, typing)
+}
+
+func (e *Emitter) EmitTyping(platform, roomID, sender string, typing bool) error {
+	return e.EmitTypingContext(context.Background(), platform, roomID, sender, typing)
+}
+
+// EmitTypingContext tells the sidecar that sender started or stopped typing.
+//
+// Typing notifications are best-effort: they are sent once without retries,
+// and a notification repeating the state last sent for the same sender in
+// the same room within TypingInterval is dropped without error.
+func (e *Emitter) EmitTypingContext(ctx context.Context, platform, roomID, sender string, typing bool) error {
+	platform = e.normalizePlatform(platform)
+	key := "typing\x00" + platform + "\x00" + roomID + "\x00" + sender
+	if e.filtered(platform, sender) || !e.throttle(key, strconv.FormatBool(typing), e.TypingInterval, DefaultTypingInterval) {
+		return nil
+	}
+	return e.send(ctx, emission{
+		platform:   platform,
+		endpoint:   "typing",
+		bestEffort: true,
+		body: TypingPayload{
+			SchemaVersion: SchemaVersion,
+			EventType:     EventTyping,
+			Platform:      platform,
+			RoomID:        roomID,
+			Sender:        sender,
+			Typing:        typing,
+		},
+	})
+}
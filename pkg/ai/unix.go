@@ -0,0 +1,55 @@
+package ai
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// unixMessageURL is the request URL used when the sidecar is reached over a
+// Unix socket. The host is ignored by the transport.
+const unixMessageURL = "http://unix/message"
+
+// unixSocket dials the socket set through a unix:// URL. The dialer is
+// applied to a clone of whichever client the emitter uses, so a custom or
+// pooled Client still reaches the socket instead of TCP.
+type unixSocket struct {
+	path string
+
+	lock   sync.Mutex
+	base   *http.Client
+	client *http.Client
+}
+
+func newUnixSocket(path string) *unixSocket {
+	return &unixSocket{path: path}
+}
+
+// clientFor returns base with its transport dialing the socket. The result
+// is cached until a different base client is passed.
+func (s *unixSocket) clientFor(base *http.Client) (*http.Client, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.client != nil && s.base == base {
+		return s.client, nil
+	}
+	var transport *http.Transport
+	switch t := base.Transport.(type) {
+	case nil:
+		transport = newDefaultTransport()
+	case *http.Transport:
+		transport = t.Clone()
+	default:
+		return nil, fmt.Errorf("ai: client transport %T can't dial a unix socket", t)
+	}
+	var dialer net.Dialer
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "unix", s.path)
+	}
+	client := *base
+	client.Transport = transport
+	s.base, s.client = base, &client
+	return s.client, nil
+}
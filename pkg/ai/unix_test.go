@@ -0,0 +1,49 @@
+package ai_test
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+)
+
+func TestUnixSocketWithCustomClient(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "sidecar.sock")
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	var requests atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	srv.Listener = listener
+	srv.Start()
+	defer srv.Close()
+
+	clients := map[string]*http.Client{
+		"default": nil,
+		"pooled":  ai.NewPooledClient(ai.PoolOptions{MaxIdleConnsPerHost: 4}),
+		"tls":     ai.NewTLSClient(nil),
+	}
+	for name, client := range clients {
+		t.Run(name, func(t *testing.T) {
+			e, err := ai.New(ai.WithURL("unix://"+socketPath), ai.WithHTTPClient(client))
+			if err != nil {
+				t.Fatal(err)
+			}
+			before := requests.Load()
+			err = e.Emit(ai.NewMessagePayload(ai.PlatformWhatsApp, "!room", "@alice", "msg1", time.Now()))
+			if err != nil {
+				t.Fatalf("Emit: %v", err)
+			} else if requests.Load() != before+1 {
+				t.Fatal("request didn't reach the unix socket")
+			}
+		})
+	}
+}
@@ -0,0 +1,349 @@
+// mautrix-whatsapp - A Matrix-WhatsApp puppeting bridge.
+// Copyright (C) 2024 Tulir Asokan
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Affero General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU Affero General Public License for more details.
+//
+// You should have received a copy of the GNU Affero General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"maunium.net/go/mautrix/bridgev2"
+	"maunium.net/go/mautrix/bridgev2/database"
+	"maunium.net/go/mautrix/event"
+
+	"github.com/mautrix/whatsapp/pkg/ai"
+	"go.mau.fi/mautrix-whatsapp/pkg/waid"
+)
+
+// aiQueueWorkers is the number of rooms whose payloads are emitted to the
+// sidecar concurrently. Payloads of a single room are always emitted in order.
+const aiQueueWorkers = 4
+
+// aiStopTimeout limits how long stopAIQueue waits for queued payloads.
+const aiStopTimeout = 5 * time.Second
+
+var (
+	aiQueue     *ai.QueueEmitter
+	aiQueueLock sync.Mutex
+)
+
+// enqueueToAI hands payload to the background queue, so that slow sidecar
+// requests never hold up the portal event loop. The queue is started on
+// first use.
+func enqueueToAI(ctx context.Context, payload ai.MessagePayload) {
+	aiQueueLock.Lock()
+	if aiQueue == nil {
+		aiQueue = ai.NewShardedQueueEmitter(aiDispatcher{}, ai.DefaultQueueSize, aiQueueWorkers)
+	}
+	queue := aiQueue
+	aiQueueLock.Unlock()
+	if err := queue.Enqueue(payload); err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).
+			Str("event_type", string(payload.EventType)).
+			Msg("Failed to queue event for AI sidecar")
+	}
+}
+
+// stopAIQueue waits for the payloads queued so far to be emitted, up to
+// aiStopTimeout. It is called by Stop; events queued afterwards are
+// rejected.
+func (wa *WhatsAppConnector) stopAIQueue() {
+	aiQueueLock.Lock()
+	queue := aiQueue
+	aiQueueLock.Unlock()
+	if queue == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), aiStopTimeout)
+	defer cancel()
+	if err := queue.Shutdown(ctx); err != nil {
+		wa.Bridge.Log.Warn().Err(err).Msg("Failed to drain AI sidecar queue")
+	}
+}
+
+// aiDispatcher emits queued payloads with the package-level ai emitter,
+// picking the endpoint by EventType. Reactions and deletions are queued as
+// message payloads too, so that they stay in order with the messages they
+// refer to: MessageID is the target message and, for reactions, Text is the
+// emoji, which is empty when the reaction was removed.
+type aiDispatcher struct{}
+
+func (aiDispatcher) EmitContext(ctx context.Context, payload ai.MessagePayload) error {
+	switch payload.EventType {
+	case ai.EventEdit:
+		return ai.EmitEditContext(ctx, payload)
+	case ai.EventReaction:
+		return ai.EmitReactionContext(ctx, ai.ReactionPayload{
+			Platform:        payload.Platform,
+			RoomID:          payload.RoomID,
+			Sender:          payload.Sender,
+			TargetMessageID: payload.MessageID,
+			Emoji:           payload.Text,
+			Removed:         payload.Text == "",
+			Timestamp:       payload.Timestamp,
+		})
+	case ai.EventDelete:
+		return ai.EmitDeleteContext(ctx, payload.Platform, payload.RoomID, payload.MessageID)
+	default:
+		return ai.EmitContext(ctx, payload)
+	}
+}
+
+// emitToAI sends a best-effort copy of an incoming message to the AI sidecar.
+func (evt *WAMessageEvent) emitToAI(ctx context.Context, portal *bridgev2.Portal, converted *bridgev2.ConvertedMessage) {
+	text := extractAIText(evt.Message)
+	attachments := convertedToAIAttachments(converted)
+	poll, location := aiPollData(evt.Message), aiLocationData(evt.Message)
+	if text == "" && len(attachments) == 0 && poll == nil && location == nil {
+		return
+	}
+	payload := ai.MessagePayload{
+		Platform:    ai.PlatformWhatsApp,
+		RoomID:      portal.MXID.String(),
+		Sender:      evt.Info.Sender.String(),
+		IsGroup:     evt.Info.IsGroup,
+		Timestamp:   evt.Info.Timestamp.UnixMilli(),
+		Text:        text,
+		MessageID:   evt.Info.ID,
+		Attachments: attachments,
+		Poll:        poll,
+		Location:    location,
+	}
+	for _, part := range converted.Parts {
+		if part.Content.Format == event.FormatHTML && part.Content.FormattedBody != "" {
+			payload.FormattedText = part.Content.FormattedBody
+			payload.Format = ai.FormatHTML
+			break
+		}
+	}
+	ghost, err := portal.Bridge.GetExistingGhostByID(ctx, waid.MakeUserID(evt.Info.Sender))
+	if err != nil {
+		zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get sender ghost for AI payload")
+	} else if ghost != nil {
+		payload.SenderName = ghost.Name
+		payload.SenderAvatarURL = string(ghost.AvatarMXC)
+	}
+	if evt.Info.IsGroup {
+		// MemberCount is left unset: neither the portal metadata nor the
+		// room info caches it, and fetching the member list here would cost
+		// a homeserver round trip for every group message.
+		payload.RoomName = portal.Name
+	}
+	contextInfo := aiContextInfo(evt.Message)
+	payload.Mentions = contextInfo.GetMentionedJID()
+	ownJID, ownLID := evt.wa.GetStore().GetJID(), evt.wa.GetStore().GetLID()
+	for _, mention := range payload.Mentions {
+		if jid, err := types.ParseJID(mention); err == nil && (jid.User == ownJID.User || jid.User == ownLID.User) {
+			payload.MentionsMe = true
+			break
+		}
+	}
+	if converted.ReplyTo != nil {
+		if parsed, err := waid.ParseMessageID(converted.ReplyTo.MessageID); err == nil {
+			payload.ReplyToMessageID = parsed.ID
+			payload.ReplyToSender = parsed.Sender.String()
+			payload.QuotedText = extractAIText(contextInfo.GetQuotedMessage())
+			quotedGhost, err := portal.Bridge.GetExistingGhostByID(ctx, waid.MakeUserID(parsed.Sender))
+			if err != nil {
+				zerolog.Ctx(ctx).Warn().Err(err).Msg("Failed to get quoted sender ghost for AI payload")
+			} else if quotedGhost != nil {
+				payload.QuotedSenderName = quotedGhost.Name
+			}
+		}
+	}
+	if converted.ThreadRoot != nil {
+		if parsed, err := waid.ParseMessageID(*converted.ThreadRoot); err == nil {
+			payload.ThreadID = parsed.ID
+		}
+	}
+	enqueueToAI(ctx, payload)
+}
+
+// emitEventToAI forwards non-message events, such as reactions, to the AI sidecar.
+func (evt *WAMessageEvent) emitEventToAI(ctx context.Context, portal *bridgev2.Portal) {
+	payload := ai.MessagePayload{
+		Platform: ai.PlatformWhatsApp,
+		RoomID:   portal.MXID.String(),
+		Sender:   evt.Info.Sender.String(),
+		IsGroup:  evt.Info.IsGroup,
+	}
+	switch evt.GetType() {
+	case bridgev2.RemoteEventReaction, bridgev2.RemoteEventReactionRemove:
+		reaction := evt.Message.GetReactionMessage()
+		payload.EventType = ai.EventReaction
+		payload.MessageID = reaction.GetKey().GetID()
+		payload.Text = reaction.GetText()
+		payload.Timestamp = evt.Info.Timestamp.UnixMilli()
+	case bridgev2.RemoteEventMessageRemove:
+		payload.EventType = ai.EventDelete
+		payload.MessageID = evt.Message.GetProtocolMessage().GetKey().GetID()
+	default:
+		return
+	}
+	enqueueToAI(ctx, payload)
+}
+
+// emitEditToAI sends the new content of an edited message to the AI sidecar.
+func (evt *WAMessageEvent) emitEditToAI(ctx context.Context, portal *bridgev2.Portal, editedMsg *waE2E.Message, original *database.Message) {
+	enqueueToAI(ctx, ai.MessagePayload{
+		EventType: ai.EventEdit,
+		Platform:  ai.PlatformWhatsApp,
+		RoomID:    portal.MXID.String(),
+		Sender:    evt.Info.Sender.String(),
+		IsGroup:   evt.Info.IsGroup,
+		Timestamp: original.Timestamp.UnixMilli(),
+		Text:      extractAIText(editedMsg),
+		MessageID: evt.Message.GetProtocolMessage().GetKey().GetID(),
+		EditedAt:  evt.Info.Timestamp.UnixMilli(),
+	})
+}
+
+// aiContextInfo returns the context info of the message types that can
+// carry mentions or replies.
+func aiContextInfo(msg *waE2E.Message) *waE2E.ContextInfo {
+	switch {
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetContextInfo()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetContextInfo()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetContextInfo()
+	case msg.GetPtvMessage() != nil:
+		return msg.GetPtvMessage().GetContextInfo()
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage().GetContextInfo()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetContextInfo()
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage().GetContextInfo()
+	case msg.GetLocationMessage() != nil:
+		return msg.GetLocationMessage().GetContextInfo()
+	case aiPollMessage(msg) != nil:
+		return aiPollMessage(msg).GetContextInfo()
+	default:
+		return nil
+	}
+}
+
+func extractAIText(msg *waE2E.Message) string {
+	switch {
+	case msg.GetConversation() != "":
+		return msg.GetConversation()
+	case msg.GetExtendedTextMessage() != nil:
+		return msg.GetExtendedTextMessage().GetText()
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage().GetCaption()
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage().GetCaption()
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage().GetCaption()
+	case aiPollMessage(msg) != nil:
+		return aiPollMessage(msg).GetName()
+	case msg.GetLocationMessage() != nil:
+		location := aiLocationData(msg)
+		if location.Label != "" {
+			return location.Label
+		}
+		return fmt.Sprintf("Location: %.6f, %.6f", location.Latitude, location.Longitude)
+	default:
+		return ""
+	}
+}
+
+// aiPollMessage returns the poll creation message of any version, if msg is one.
+func aiPollMessage(msg *waE2E.Message) *waE2E.PollCreationMessage {
+	switch {
+	case msg.GetPollCreationMessage() != nil:
+		return msg.GetPollCreationMessage()
+	case msg.GetPollCreationMessageV2() != nil:
+		return msg.GetPollCreationMessageV2()
+	case msg.GetPollCreationMessageV3() != nil:
+		return msg.GetPollCreationMessageV3()
+	default:
+		return msg.GetPollCreationMessageV5()
+	}
+}
+
+func aiPollData(msg *waE2E.Message) *ai.PollData {
+	poll := aiPollMessage(msg)
+	if poll == nil {
+		return nil
+	}
+	data := &ai.PollData{Question: poll.GetName()}
+	for _, option := range poll.GetOptions() {
+		data.Options = append(data.Options, option.GetOptionName())
+	}
+	return data
+}
+
+func aiLocationData(msg *waE2E.Message) *ai.LocationData {
+	location := msg.GetLocationMessage()
+	if location == nil {
+		return nil
+	}
+	label := location.GetName()
+	if address := location.GetAddress(); label == "" {
+		label = address
+	} else if address != "" {
+		label += ", " + address
+	}
+	return &ai.LocationData{
+		Latitude:  location.GetDegreesLatitude(),
+		Longitude: location.GetDegreesLongitude(),
+		Label:     label,
+	}
+}
+
+func convertedToAIAttachments(converted *bridgev2.ConvertedMessage) []ai.Attachment {
+	var attachments []ai.Attachment
+	for _, part := range converted.Parts {
+		content := part.Content
+		var attType ai.AttachmentType
+		switch {
+		case part.Type == event.EventSticker, content.MsgType == event.MsgImage:
+			attType = ai.AttachmentImage
+		case content.MsgType == event.MsgVideo:
+			attType = ai.AttachmentVideo
+		case content.MsgType == event.MsgAudio:
+			attType = ai.AttachmentAudio
+		case content.MsgType == event.MsgFile:
+			attType = ai.AttachmentFile
+		default:
+			continue
+		}
+		attachment := ai.Attachment{
+			Type: attType,
+			MXC:  string(content.URL),
+		}
+		if content.File != nil {
+			attachment.MXC = string(content.File.URL)
+		}
+		if content.Info != nil {
+			attachment.MimeType = content.Info.MimeType
+			attachment.Size = int64(content.Info.Size)
+		}
+		if content.FileName != "" && content.Body != content.FileName {
+			attachment.Caption = content.Body
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
@@ -36,12 +36,9 @@ import (
 	"maunium.net/go/mautrix/format"
 
 	"go.mau.fi/mautrix-whatsapp/pkg/msgconv"
-	"github.com/mautrix/whatsapp/pkg/ai"
 	"go.mau.fi/mautrix-whatsapp/pkg/waid"
 )
 
-
-
 func (wa *WhatsAppClient) getPortalKeyByMessageSource(ms types.MessageSource) networkid.PortalKey {
 	jid := ms.Chat
 	if ms.IsIncomingBroadcast() {
@@ -168,6 +165,7 @@ func (evt *WAMessageEvent) PostHandle(ctx context.Context, portal *bridgev2.Port
 		evt.postHandle = nil
 		ph()
 	}
+	evt.emitEventToAI(ctx, portal)
 }
 
 func (evt *WAMessageEvent) ConvertEdit(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI, existing []*database.Message) (*bridgev2.ConvertedEdit, error) {
@@ -204,6 +202,8 @@ func (evt *WAMessageEvent) ConvertEdit(ctx context.Context, portal *bridgev2.Por
 			editPart.TopLevelExtra = make(map[string]any)
 		}
 		editPart.TopLevelExtra["com.beeper.dont_render_edited"] = true
+	} else {
+		evt.emitEditToAI(ctx, portal, editedMsg, existing[0])
 	}
 	return &bridgev2.ConvertedEdit{
 		ModifiedParts: []*bridgev2.ConvertedEditPart{editPart},
@@ -274,42 +274,6 @@ func (evt *WAMessageEvent) HandleExisting(ctx context.Context, portal *bridgev2.
 func (evt *WAMessageEvent) ConvertMessage(ctx context.Context, portal *bridgev2.Portal, intent bridgev2.MatrixAPI) (*bridgev2.ConvertedMessage, error) {
 	evt.wa.EnqueuePortalResync(portal, false)
 
-	// Best-effort extract human-readable text for AI processing and emit once
-	var extractedText string
-	var raw map[string]any
-	if evt.MsgEvent.RawMessage != nil {
-		if err := json.Unmarshal(evt.MsgEvent.RawMessage, &raw); err == nil {
-			if v, ok := raw["conversation"].(string); ok {
-				extractedText = v
-			} else if ext, ok := raw["extendedTextMessage"].(map[string]any); ok {
-				if t, ok := ext["text"].(string); ok { extractedText = t }
-			} else if img, ok := raw["imageMessage"].(map[string]any); ok {
-				if c, ok := img["caption"].(string); ok { extractedText = c }
-			} else if vid, ok := raw["videoMessage"].(map[string]any); ok {
-				if c, ok := vid["caption"].(string); ok { extractedText = c }
-			} else if doc, ok := raw["documentMessage"].(map[string]any); ok {
-				if c, ok := doc["caption"].(string); ok { extractedText = c }
-			}
-		}
-	}
-	if extractedText == "" {
-	return evt.wa.Main.MsgConv.ToMatrix(
-		ctx, portal, evt.wa.Client, intent,
-		evt.Message, evt.MsgEvent.RawMessage,
-		&evt.Info, evt.isViewOnce(), false, nil,
-	)
-}
-	ai.Emit(ai.MessagePayload{
-		Platform:   "whatsapp",
-		RoomID:     portal.MXID.String(),
-		Sender:     evt.Info.Sender.String(),
-		SenderName: "", // not available reliably here
-		IsGroup:    portal.IsGroup,
-		Timestamp:  evt.Info.Timestamp.Unix(),
-		Text:       extractedText,
-		MessageID:  evt.Info.ID,
-	})
-
 	converted := evt.wa.Main.MsgConv.ToMatrix(
 		ctx, portal, evt.wa.Client, intent, evt.Message, evt.MsgEvent.RawMessage, &evt.Info, evt.isViewOnce(), false, nil,
 	)
@@ -320,6 +284,7 @@ func (evt *WAMessageEvent) ConvertMessage(ctx context.Context, portal *bridgev2.
 	} else if len(converted.Parts) > 0 {
 		evt.wa.Main.AddMediaEditCache(portal, evt.GetID(), converted.Parts[0])
 	}
+	evt.emitToAI(ctx, portal, converted)
 	return converted, nil
 }
 